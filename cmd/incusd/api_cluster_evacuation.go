@@ -597,14 +597,19 @@ func evacuateClusterSelectTarget(ctx context.Context, s *state.State, inst insta
 			reqExpanded.Profiles = append(reqExpanded.Profiles, p.Name)
 		}
 
-		ctx, cancel := context.WithTimeout(ctx, time.Second*5)
-		targetMemberInfo, err = scriptlet.InstancePlacementRun(ctx, logger.Log, s, &reqExpanded, candidateMembers, leaderAddress)
+		reqExpanded.ConfigSources = db.ExpandInstanceConfigSources(inst.LocalConfig(), inst.Profiles())
+
+		// Each run is bounded by the configured scriptlet timeout.
+		placement, err := scriptlet.InstancePlacementRunWithRetry(ctx, logger.Log, s, &reqExpanded, candidateMembers, leaderAddress)
 		if err != nil {
-			cancel()
 			return nil, nil, fmt.Errorf("Failed instance placement scriptlet for instance %q in project %q: %w", inst.Name(), inst.Project().Name, err)
 		}
 
-		cancel()
+		targetMemberInfo = placement.Member
+
+		for _, err := range placement.RemoteErrors {
+			logger.Warn("Instance placement scriptlet ignored a cluster member failure", logger.Ctx{"err": err})
+		}
 	}
 
 	// If target member not specified yet, then find the least loaded cluster member which
@@ -16,6 +16,7 @@ import (
 	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"golang.org/x/sys/unix"
@@ -31,6 +32,7 @@ import (
 	deviceConfig "github.com/lxc/incus/v6/internal/server/device/config"
 	"github.com/lxc/incus/v6/internal/server/instance"
 	"github.com/lxc/incus/v6/internal/server/instance/instancetype"
+	"github.com/lxc/incus/v6/internal/server/metrics"
 	"github.com/lxc/incus/v6/internal/server/project"
 	"github.com/lxc/incus/v6/internal/server/request"
 	"github.com/lxc/incus/v6/internal/server/response"
@@ -61,6 +63,7 @@ var apiInternal = []APIEndpoint{
 	internalGarbageCollectorCmd,
 	internalImageOptimizeCmd,
 	internalImageRefreshCmd,
+	internalMetricsHistoryCmd,
 	internalRAFTSnapshotCmd,
 	internalRebalanceLoadCmd,
 	internalReadyCmd,
@@ -118,6 +121,12 @@ var internalGarbageCollectorCmd = APIEndpoint{
 	Get: APIEndpointAction{Handler: internalGC, AccessHandler: allowPermission(auth.ObjectTypeServer, auth.EntitlementCanEdit)},
 }
 
+var internalMetricsHistoryCmd = APIEndpoint{
+	Path: "metrics/history",
+
+	Get: APIEndpointAction{Handler: internalMetricsHistory, AccessHandler: allowPermission(auth.ObjectTypeServer, auth.EntitlementCanEdit)},
+}
+
 var internalRAFTSnapshotCmd = APIEndpoint{
 	Path: "raft-snapshot",
 
@@ -1053,6 +1062,23 @@ func internalImportRootDevicePopulate(instancePoolName string, localDevices map[
 	}
 }
 
+// internalMetricsHistory returns the resource usage samples of the local member.
+// The since query parameter is a Unix timestamp limiting the returned samples.
+func internalMetricsHistory(d *Daemon, r *http.Request) response.Response {
+	var since int64
+
+	if request.QueryParam(r, "since") != "" {
+		var err error
+
+		since, err = strconv.ParseInt(request.QueryParam(r, "since"), 10, 64)
+		if err != nil {
+			return response.BadRequest(fmt.Errorf("Invalid since value: %w", err))
+		}
+	}
+
+	return response.SyncResponse(true, metrics.LocalMemberHistory.Since(time.Unix(since, 0)))
+}
+
 func internalGC(d *Daemon, r *http.Request) response.Response {
 	logger.Infof("Started forced garbage collection run")
 	runtime.GC()
@@ -12,6 +12,8 @@ import (
 	"sync"
 	"time"
 
+	"golang.org/x/sys/unix"
+
 	"github.com/lxc/incus/v6/internal/server/auth"
 	"github.com/lxc/incus/v6/internal/server/db"
 	dbCluster "github.com/lxc/incus/v6/internal/server/db/cluster"
@@ -22,7 +24,9 @@ import (
 	"github.com/lxc/incus/v6/internal/server/request"
 	"github.com/lxc/incus/v6/internal/server/response"
 	"github.com/lxc/incus/v6/internal/server/state"
+	"github.com/lxc/incus/v6/internal/server/task"
 	"github.com/lxc/incus/v6/shared/api"
+	apiScriptlet "github.com/lxc/incus/v6/shared/api/scriptlet"
 	"github.com/lxc/incus/v6/shared/logger"
 )
 
@@ -331,6 +335,9 @@ func internalMetrics(ctx context.Context, daemonStartTime time.Time, tx *db.Clus
 		out.AddSamples(metrics.OperationsTotal, metrics.Sample{Value: float64(len(operations))})
 	}
 
+	// OVN chassis
+	out.Merge(networkOVNChassisMetrics())
+
 	// Daemon uptime
 	out.AddSamples(metrics.UptimeSeconds, metrics.Sample{Value: time.Since(daemonStartTime).Seconds()})
 
@@ -367,3 +374,30 @@ func internalMetrics(ctx context.Context, daemonStartTime time.Time, tx *db.Clus
 
 	return out
 }
+
+// memberMetricsHistoryTask samples the local member resource usage for use by the instance placement scriptlet.
+func memberMetricsHistoryTask() (task.Func, task.Schedule) {
+	f := func(ctx context.Context) {
+		info := unix.Sysinfo_t{}
+		err := unix.Sysinfo(&info)
+		if err != nil {
+			logger.Warn("Failed getting sysinfo", logger.Ctx{"err": err})
+			return
+		}
+
+		// Account for different representations of Sysinfo_t on different architectures.
+		unit := uint64(info.Unit)
+		if unit == 0 {
+			unit = 1
+		}
+
+		metrics.LocalMemberHistory.Add(apiScriptlet.MemberMetricsSample{
+			Timestamp:   time.Now().Unix(),
+			LoadAverage: float64(info.Loads[0]) / 65536,
+			MemoryTotal: uint64(info.Totalram) * unit,
+			MemoryUsed:  (uint64(info.Totalram) - uint64(info.Freeram)) * unit,
+		})
+	}
+
+	return f, task.Every(metrics.MemberHistoryInterval)
+}
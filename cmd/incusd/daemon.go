@@ -1736,6 +1736,9 @@ func (d *Daemon) init() error {
 
 		// Remove expired tokens (hourly)
 		d.tasks.Add(autoRemoveExpiredTokensTask(d))
+
+		// Sample the member resource usage for the instance placement scriptlet (minutely)
+		d.tasks.Add(memberMetricsHistoryTask())
 	}
 
 	// Start all background tasks
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -405,20 +406,37 @@ func instancePost(d *Daemon, r *http.Request) response.Response {
 						Profiles: profileNames,
 					},
 				},
-				Project: instProject,
-				Reason:  apiScriptlet.InstancePlacementReasonRelocation,
+				Project:       instProject,
+				Reason:        apiScriptlet.InstancePlacementReasonRelocation,
+				ConfigSources: db.ExpandInstanceConfigSources(inst.LocalConfig(), profiles),
 			}
 
 			if targetMemberInfo == nil {
 				// Get a new target.
-				targetMemberInfo, err = scriptlet.InstancePlacementRun(r.Context(), logger.Log, s, &req, targetCandidates, leaderAddress)
+				placement, err := scriptlet.InstancePlacementRunWithRetry(r.Context(), logger.Log, s, &req, targetCandidates, leaderAddress)
 				if err != nil {
+					var rejectedErr scriptlet.ErrInstancePlacementRejected
+					if errors.As(err, &rejectedErr) {
+						return response.BadRequest(err)
+					}
+
 					return response.BadRequest(fmt.Errorf("Failed instance placement scriptlet: %w", err))
 				}
+
+				targetMemberInfo = placement.Member
+
+				for _, err := range placement.RemoteErrors {
+					logger.Warn("Instance placement scriptlet ignored a cluster member failure", logger.Ctx{"err": err})
+				}
 			} else {
 				// Validate the current target.
-				_, err = scriptlet.InstancePlacementRun(r.Context(), logger.Log, s, &req, targetCandidates, leaderAddress)
+				_, err = scriptlet.InstancePlacementRunWithRetry(r.Context(), logger.Log, s, &req, targetCandidates, leaderAddress)
 				if err != nil {
+					var rejectedErr scriptlet.ErrInstancePlacementRejected
+					if errors.As(err, &rejectedErr) {
+						return response.BadRequest(err)
+					}
+
 					return response.BadRequest(fmt.Errorf("Failed instance placement scriptlet: %w", err))
 				}
 			}
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"net/http"
 	"os"
 	"slices"
@@ -952,6 +953,11 @@ func instancesPost(d *Daemon, r *http.Request) response.Response {
 	var targetMemberInfo *db.NodeInfo
 	var targetGroupName string
 
+	// Further targets set by the placement scriptlet, tried in order if forwarding to the selected member fails.
+	var placementFallbacks []scriptlet.InstancePlacementTarget
+	var placementDevices map[string]map[string]string
+	var placementExpandedDevices map[string]map[string]string
+
 	err = s.DB.Cluster.Transaction(r.Context(), func(ctx context.Context, tx *db.ClusterTx) error {
 		target := request.QueryParam(r, "target")
 		if !s.ServerClustered && target != "" {
@@ -1184,12 +1190,59 @@ func instancesPost(d *Daemon, r *http.Request) response.Response {
 			}
 
 			reqExpanded.Config = db.ExpandInstanceConfig(reqExpanded.Config, profiles)
+			reqExpanded.ConfigSources = db.ExpandInstanceConfigSources(req.Config, profiles)
 			reqExpanded.Devices = db.ExpandInstanceDevices(deviceConfig.NewDevices(reqExpanded.Devices), profiles).CloneNative()
 
-			targetMemberInfo, err = scriptlet.InstancePlacementRun(r.Context(), logger.Log, s, &reqExpanded, candidateMembers, leaderAddress)
+			placement, err := scriptlet.InstancePlacementRunWithRetry(r.Context(), logger.Log, s, &reqExpanded, candidateMembers, leaderAddress)
 			if err != nil {
+				var rejectedErr scriptlet.ErrInstancePlacementRejected
+				if errors.As(err, &rejectedErr) {
+					return response.BadRequest(err)
+				}
+
 				return response.SmartError(fmt.Errorf("Failed instance placement scriptlet: %w", err))
 			}
+
+			targetMemberInfo = placement.Member
+			if len(placement.Targets) > 1 {
+				placementFallbacks = placement.Targets[1:]
+			}
+
+			for _, err := range placement.RemoteErrors {
+				logger.Warn("Instance placement scriptlet ignored a cluster member failure", logger.Ctx{"err": err})
+			}
+
+			// Apply the configuration overrides requested by the scriptlet.
+			for k, v := range placement.ConfigOverrides {
+				req.Config[k] = v
+			}
+
+			// Use the storage pool selected by the scriptlet for the root disk.
+			placementDevices = maps.Clone(req.Devices)
+			placementExpandedDevices = reqExpanded.Devices
+			if placement.Pool != "" {
+				instancesPostSetRootPool(&req, placementExpandedDevices, placement.Pool)
+			}
+
+			// The project checks ran before the scriptlet, check the request again with its changes applied.
+			err = instancesPostAllowPlacement(r.Context(), s, targetProjectName, req, placement.Pool)
+			if err != nil {
+				return response.BadRequest(fmt.Errorf("Instance placement not allowed: %w", err))
+			}
+
+			// Fallback targets are only tried after forwarding failed, check them upfront too.
+			for _, fallback := range placementFallbacks {
+				fallbackReq := req
+				fallbackReq.Devices = maps.Clone(placementDevices)
+				if fallback.Pool != "" {
+					instancesPostSetRootPool(&fallbackReq, placementExpandedDevices, fallback.Pool)
+				}
+
+				err = instancesPostAllowPlacement(r.Context(), s, targetProjectName, fallbackReq, fallback.Pool)
+				if err != nil {
+					return response.BadRequest(fmt.Errorf("Instance placement on %q not allowed: %w", fallback.Member.Name, err))
+				}
+			}
 		}
 
 		// If no target member was selected yet, pick the member with the least number of instances.
@@ -1207,23 +1260,26 @@ func instancesPost(d *Daemon, r *http.Request) response.Response {
 		req.Config["volatile.cluster.group"] = targetGroupName
 	}
 
-	if targetMemberInfo != nil && targetMemberInfo.Address != "" && targetMemberInfo.Name != s.ServerName {
-		client, err := cluster.Connect(targetMemberInfo.Address, s.Endpoints.NetworkCert(), s.ServerCert(), r, true)
-		if err != nil {
+	for targetMemberInfo != nil && targetMemberInfo.Address != "" && targetMemberInfo.Name != s.ServerName {
+		opAPI, err := instancesPostForward(s, r, targetProjectName, targetMemberInfo, req)
+		if err == nil {
+			return operations.ForwardedOperationResponse(targetProjectName, opAPI)
+		}
+
+		if len(placementFallbacks) == 0 {
 			return response.SmartError(err)
 		}
 
-		client = client.UseProject(targetProjectName)
-		client = client.UseTarget(targetMemberInfo.Name)
+		// Move on to the next target set by the placement scriptlet, which may be the local member.
+		logger.Warn("Failed forwarding instance creation, trying next placement target", logger.Ctx{"target": targetMemberInfo.Name, "next": placementFallbacks[0].Member.Name, "err": err})
 
-		logger.Debug("Forward instance post request", logger.Ctx{"local": s.ServerName, "target": targetMemberInfo.Name, "targetAddress": targetMemberInfo.Address})
-		op, err := client.CreateInstance(req)
-		if err != nil {
-			return response.SmartError(err)
+		targetMemberInfo = placementFallbacks[0].Member
+		req.Devices = maps.Clone(placementDevices)
+		if placementFallbacks[0].Pool != "" {
+			instancesPostSetRootPool(&req, placementExpandedDevices, placementFallbacks[0].Pool)
 		}
 
-		opAPI := op.Get()
-		return operations.ForwardedOperationResponse(targetProjectName, &opAPI)
+		placementFallbacks = placementFallbacks[1:]
 	}
 
 	switch req.Source.Type {
@@ -1240,6 +1296,66 @@ func instancesPost(d *Daemon, r *http.Request) response.Response {
 	}
 }
 
+// instancesPostForward forwards the instance creation request to the given cluster member.
+func instancesPostForward(s *state.State, r *http.Request, projectName string, member *db.NodeInfo, req api.InstancesPost) (*api.Operation, error) {
+	client, err := cluster.Connect(member.Address, s.Endpoints.NetworkCert(), s.ServerCert(), r, true)
+	if err != nil {
+		return nil, err
+	}
+
+	client = client.UseProject(projectName)
+	client = client.UseTarget(member.Name)
+
+	logger.Debug("Forward instance post request", logger.Ctx{"local": s.ServerName, "target": member.Name, "targetAddress": member.Address})
+	op, err := client.CreateInstance(req)
+	if err != nil {
+		return nil, err
+	}
+
+	opAPI := op.Get()
+
+	return &opAPI, nil
+}
+
+// instancesPostAllowPlacement checks the request modified by the instance placement scriptlet against the
+// project's limits and restrictions, including the storage pool it selected (if any) being available to the
+// project. Forwarded requests skip those checks on the target member.
+func instancesPostAllowPlacement(ctx context.Context, s *state.State, projectName string, req api.InstancesPost, pool string) error {
+	return s.DB.Cluster.Transaction(ctx, func(ctx context.Context, tx *db.ClusterTx) error {
+		if pool != "" {
+			hiddenPools, err := project.HiddenStoragePools(ctx, tx, projectName)
+			if err != nil {
+				return err
+			}
+
+			if slices.Contains(hiddenPools, pool) {
+				return fmt.Errorf("Storage pool %q isn't available in project %q", pool, projectName)
+			}
+		}
+
+		return project.AllowInstanceCreation(tx, projectName, req)
+	})
+}
+
+// instancesPostSetRootPool points the root disk of the request at the given storage pool.
+// The rest of the root disk configuration is taken from the expanded devices, as it may come from a profile.
+func instancesPostSetRootPool(req *api.InstancesPost, expandedDevices map[string]map[string]string, pool string) {
+	rootDevName, rootDev, err := internalInstance.GetRootDiskDevice(expandedDevices)
+	if err != nil {
+		rootDevName = "root"
+		rootDev = map[string]string{"type": "disk", "path": "/"}
+	}
+
+	rootDev = maps.Clone(rootDev)
+	rootDev["pool"] = pool
+
+	if req.Devices == nil {
+		req.Devices = map[string]map[string]string{}
+	}
+
+	req.Devices[rootDevName] = rootDev
+}
+
 func instanceFindStoragePool(ctx context.Context, s *state.State, projectName string, req *api.InstancesPost) (string, string, string, map[string]string, response.Response) {
 	// Grab the container's root device if one is specified
 	storagePool := ""
@@ -1,19 +1,46 @@
 package main
 
 import (
+	"sync"
+	"time"
+
 	"github.com/lxc/incus/v6/internal/server/cluster"
 	"github.com/lxc/incus/v6/internal/server/db"
+	"github.com/lxc/incus/v6/internal/server/lifecycle"
+	"github.com/lxc/incus/v6/internal/server/metrics"
 	"github.com/lxc/incus/v6/internal/server/state"
+	"github.com/lxc/incus/v6/shared/api"
 	"github.com/lxc/incus/v6/shared/logger"
 )
 
 var networkOVNChassis *bool
+var networkOVNChassisMu sync.Mutex
+var networkOVNChassisRestartTimer *time.Timer
+
+// Values from the last heartbeat, used for metrics.
+var networkOVNChassisMembers *int
+var networkOVNChassisLocal bool
+
+// networkOVNChassisRestartDelay is how long the chassis state must stay unchanged before OVN networks get restarted.
+// This coalesces repeated flips caused by rapid heartbeats (e.g. during a rolling upgrade) into a single restart.
+var networkOVNChassisRestartDelay = 10 * time.Second
+
+// networkOVNChassisRestart restarts the OVN networks following a local chassis change.
+var networkOVNChassisRestart = networkRestartOVN
+
+// networkOVNChassisRestartMaxRetries is how many times a failed restart gets retried on later heartbeats before giving up.
+// Each retry doubles the quiet period to avoid restarting in a tight loop.
+var networkOVNChassisRestartMaxRetries = 5
+
+// Number of consecutive failed restarts.
+var networkOVNChassisRestartFailures int
 
 // networkUpdateOVNChassis gets called on heartbeats to check if OVN needs reconfiguring.
 func networkUpdateOVNChassis(s *state.State, heartbeatData *cluster.APIHeartbeat, localAddress string) error {
 	// Check if we have at least one active OVN chassis.
 	hasOVNChassis := false
 	localOVNChassis := false
+	chassisMembers := 0
 	for _, n := range heartbeatData.Members {
 		for _, role := range n.Roles {
 			if role == db.ClusterRoleOVNChassis {
@@ -22,20 +49,111 @@ func networkUpdateOVNChassis(s *state.State, heartbeatData *cluster.APIHeartbeat
 				}
 
 				hasOVNChassis = true
+				chassisMembers++
 				break
 			}
 		}
 	}
 
 	runChassis := !hasOVNChassis || localOVNChassis
-	if networkOVNChassis != nil && *networkOVNChassis != runChassis {
-		// Detected that the local OVN chassis setup may be incorrect, restarting.
-		err := networkRestartOVN(s)
-		if err != nil {
-			logger.Error("Error restarting OVN networks", logger.Ctx{"err": err})
+
+	networkOVNChassisMu.Lock()
+	defer networkOVNChassisMu.Unlock()
+
+	networkOVNChassisMembers = &chassisMembers
+	networkOVNChassisLocal = runChassis
+
+	if networkOVNChassis == nil {
+		networkOVNChassis = &runChassis
+		return nil
+	}
+
+	if *networkOVNChassis == runChassis {
+		// The state went back to what's currently applied, cancel any pending restart.
+		if networkOVNChassisRestartTimer != nil {
+			networkOVNChassisRestartTimer.Stop()
+			networkOVNChassisRestartTimer = nil
 		}
+
+		// No restart is needed anymore, so a later change starts again with the base delay.
+		networkOVNChassisRestartFailures = 0
+
+		return nil
+	}
+
+	// Detected that the local OVN chassis setup may be incorrect, schedule a restart once the state settles.
+	if networkOVNChassisRestartTimer != nil {
+		networkOVNChassisRestartTimer.Stop()
 	}
 
-	networkOVNChassis = &runChassis
+	delay := networkOVNChassisRestartDelay << networkOVNChassisRestartFailures
+
+	var timer *time.Timer
+	timer = time.AfterFunc(delay, func() {
+		networkOVNChassisMu.Lock()
+		if networkOVNChassisRestartTimer != timer {
+			// Superseded or cancelled while waiting for the lock.
+			networkOVNChassisMu.Unlock()
+			return
+		}
+
+		previousChassis := networkOVNChassis
+		networkOVNChassis = &runChassis
+		networkOVNChassisRestartTimer = nil
+		networkOVNChassisMu.Unlock()
+
+		err := networkOVNChassisRestart(s)
+
+		networkOVNChassisMu.Lock()
+		if err != nil && networkOVNChassisRestartFailures < networkOVNChassisRestartMaxRetries {
+			// Restore the previous state so that the next heartbeat retries the restart.
+			networkOVNChassisRestartFailures++
+			attempt := networkOVNChassisRestartFailures
+			if networkOVNChassis == &runChassis {
+				networkOVNChassis = previousChassis
+			}
+
+			networkOVNChassisMu.Unlock()
+
+			logger.Error("Error restarting OVN networks, will retry", logger.Ctx{"err": err, "attempt": attempt})
+			return
+		}
+
+		networkOVNChassisRestartFailures = 0
+		networkOVNChassisMu.Unlock()
+
+		if err != nil {
+			// The new chassis state was never applied, so don't report it.
+			logger.Error("Error restarting OVN networks, giving up", logger.Ctx{"err": err})
+			return
+		}
+
+		s.Events.SendLifecycle(api.ProjectDefaultName, lifecycle.ClusterMemberOVNChassisUpdated.Event(s.ServerName, nil, map[string]any{"address": localAddress, "active": runChassis}))
+	})
+
+	networkOVNChassisRestartTimer = timer
+
 	return nil
 }
+
+// networkOVNChassisMetrics returns the OVN chassis metrics recorded during the last heartbeat.
+// Returns nil if no heartbeat has been processed yet.
+func networkOVNChassisMetrics() *metrics.MetricSet {
+	networkOVNChassisMu.Lock()
+	defer networkOVNChassisMu.Unlock()
+
+	if networkOVNChassisMembers == nil {
+		return nil
+	}
+
+	local := 0.0
+	if networkOVNChassisLocal {
+		local = 1
+	}
+
+	out := metrics.NewMetricSet(nil)
+	out.AddSamples(metrics.OVNChassisMembers, metrics.Sample{Value: float64(*networkOVNChassisMembers)})
+	out.AddSamples(metrics.OVNChassisLocal, metrics.Sample{Value: local})
+
+	return out
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/lxc/incus/v6/internal/server/cluster"
+	"github.com/lxc/incus/v6/internal/server/db"
+	"github.com/lxc/incus/v6/internal/server/events"
+	"github.com/lxc/incus/v6/internal/server/state"
+	"github.com/lxc/incus/v6/shared/api"
+)
+
+const ovnChassisLocalAddress = "10.0.0.1:8443"
+
+// ovnChassisHeartbeat returns a heartbeat where only the members at the given addresses are OVN chassis.
+func ovnChassisHeartbeat(chassisAddresses ...string) *cluster.APIHeartbeat {
+	hb := &cluster.APIHeartbeat{Members: map[int64]cluster.APIHeartbeatMember{
+		1: {ID: 1, Address: ovnChassisLocalAddress, Name: "node1"},
+		2: {ID: 2, Address: "10.0.0.2:8443", Name: "node2"},
+	}}
+
+	for id, member := range hb.Members {
+		for _, address := range chassisAddresses {
+			if member.Address == address {
+				member.Roles = []db.ClusterRole{db.ClusterRoleOVNChassis}
+				hb.Members[id] = member
+			}
+		}
+	}
+
+	return hb
+}
+
+// ovnChassisTest records the restarts and lifecycle events triggered by networkUpdateOVNChassis.
+type ovnChassisTest struct {
+	mu       sync.Mutex
+	restarts int
+	events   []api.EventLifecycle
+}
+
+func (o *ovnChassisTest) restartCount() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return o.restarts
+}
+
+func (o *ovnChassisTest) lifecycleEvents() []api.EventLifecycle {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return append([]api.EventLifecycle(nil), o.events...)
+}
+
+// setupOVNChassisTest resets the OVN chassis state and replaces the restart logic for the duration of the test.
+func setupOVNChassisTest(t *testing.T) (*state.State, *ovnChassisTest) {
+	o := &ovnChassisTest{}
+
+	s := &state.State{
+		ServerName: "node1",
+		Events: events.NewServer(false, false, func(event api.Event) {
+			if event.Type != api.EventTypeLifecycle {
+				return
+			}
+
+			lifecycleEvent := api.EventLifecycle{}
+			err := json.Unmarshal(event.Metadata, &lifecycleEvent)
+			if err != nil {
+				return
+			}
+
+			o.mu.Lock()
+			o.events = append(o.events, lifecycleEvent)
+			o.mu.Unlock()
+		}),
+	}
+
+	oldRestart := networkOVNChassisRestart
+	oldDelay := networkOVNChassisRestartDelay
+
+	networkOVNChassisRestart = func(s *state.State) error {
+		o.mu.Lock()
+		o.restarts++
+		o.mu.Unlock()
+
+		return nil
+	}
+
+	networkOVNChassisRestartDelay = 50 * time.Millisecond
+	networkOVNChassis = nil
+	networkOVNChassisMembers = nil
+	networkOVNChassisRestartFailures = 0
+
+	t.Cleanup(func() {
+		networkOVNChassisMu.Lock()
+		if networkOVNChassisRestartTimer != nil {
+			networkOVNChassisRestartTimer.Stop()
+			networkOVNChassisRestartTimer = nil
+		}
+
+		networkOVNChassis = nil
+		networkOVNChassisMembers = nil
+		networkOVNChassisRestartFailures = 0
+		networkOVNChassisMu.Unlock()
+
+		networkOVNChassisRestart = oldRestart
+		networkOVNChassisRestartDelay = oldDelay
+	})
+
+	return s, o
+}
+
+// Test that a lifecycle event is emitted when the local chassis state changes but not on a no-op heartbeat.
+func TestNetworkUpdateOVNChassis_LifecycleEvent(t *testing.T) {
+	s, o := setupOVNChassisTest(t)
+
+	// Initial heartbeat only records the state.
+	require.NoError(t, networkUpdateOVNChassis(s, ovnChassisHeartbeat(ovnChassisLocalAddress), ovnChassisLocalAddress))
+
+	// Same state again is a no-op.
+	require.NoError(t, networkUpdateOVNChassis(s, ovnChassisHeartbeat(ovnChassisLocalAddress), ovnChassisLocalAddress))
+	time.Sleep(2 * networkOVNChassisRestartDelay)
+	assert.Empty(t, o.lifecycleEvents())
+
+	// Chassis role moving to another member deactivates the local chassis.
+	require.NoError(t, networkUpdateOVNChassis(s, ovnChassisHeartbeat("10.0.0.2:8443"), ovnChassisLocalAddress))
+	require.Eventually(t, func() bool { return len(o.lifecycleEvents()) == 1 }, time.Second, 10*time.Millisecond)
+
+	event := o.lifecycleEvents()[0]
+	assert.Equal(t, api.EventLifecycleClusterMemberOVNChassisUpdated, event.Action)
+	assert.Equal(t, ovnChassisLocalAddress, event.Context["address"])
+	assert.Equal(t, false, event.Context["active"])
+	assert.Equal(t, 1, o.restartCount())
+}
+
+// Test that rapid chassis flips are coalesced into a single restart.
+func TestNetworkUpdateOVNChassis_Debounce(t *testing.T) {
+	s, o := setupOVNChassisTest(t)
+
+	require.NoError(t, networkUpdateOVNChassis(s, ovnChassisHeartbeat(ovnChassisLocalAddress), ovnChassisLocalAddress))
+
+	// Flip away, back and away again within the quiet period.
+	require.NoError(t, networkUpdateOVNChassis(s, ovnChassisHeartbeat("10.0.0.2:8443"), ovnChassisLocalAddress))
+	require.NoError(t, networkUpdateOVNChassis(s, ovnChassisHeartbeat(ovnChassisLocalAddress), ovnChassisLocalAddress))
+	require.NoError(t, networkUpdateOVNChassis(s, ovnChassisHeartbeat("10.0.0.2:8443"), ovnChassisLocalAddress))
+
+	time.Sleep(4 * networkOVNChassisRestartDelay)
+	assert.Equal(t, 1, o.restartCount())
+	assert.Len(t, o.lifecycleEvents(), 1)
+
+	// A flip that reverts before the restart fires is cancelled.
+	require.NoError(t, networkUpdateOVNChassis(s, ovnChassisHeartbeat(ovnChassisLocalAddress), ovnChassisLocalAddress))
+	require.NoError(t, networkUpdateOVNChassis(s, ovnChassisHeartbeat("10.0.0.2:8443"), ovnChassisLocalAddress))
+
+	time.Sleep(4 * networkOVNChassisRestartDelay)
+	assert.Equal(t, 1, o.restartCount())
+}
+
+// Test that a failed restart is retried on the next heartbeat.
+func TestNetworkUpdateOVNChassis_RestartRetry(t *testing.T) {
+	s, o := setupOVNChassisTest(t)
+
+	failures := 1
+	networkOVNChassisRestart = func(s *state.State) error {
+		o.mu.Lock()
+		defer o.mu.Unlock()
+
+		o.restarts++
+		if failures > 0 {
+			failures--
+			return errors.New("Restart failed")
+		}
+
+		return nil
+	}
+
+	require.NoError(t, networkUpdateOVNChassis(s, ovnChassisHeartbeat(ovnChassisLocalAddress), ovnChassisLocalAddress))
+
+	// The first restart fails and leaves the applied state unchanged.
+	require.NoError(t, networkUpdateOVNChassis(s, ovnChassisHeartbeat("10.0.0.2:8443"), ovnChassisLocalAddress))
+	require.Eventually(t, func() bool { return o.restartCount() == 1 }, time.Second, 10*time.Millisecond)
+	time.Sleep(networkOVNChassisRestartDelay)
+	assert.Empty(t, o.lifecycleEvents())
+
+	networkOVNChassisMu.Lock()
+	assert.True(t, *networkOVNChassis)
+	assert.Equal(t, 1, networkOVNChassisRestartFailures)
+	networkOVNChassisMu.Unlock()
+
+	// The next heartbeat retries the restart, which now succeeds.
+	require.NoError(t, networkUpdateOVNChassis(s, ovnChassisHeartbeat("10.0.0.2:8443"), ovnChassisLocalAddress))
+	require.Eventually(t, func() bool { return len(o.lifecycleEvents()) == 1 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, 2, o.restartCount())
+
+	networkOVNChassisMu.Lock()
+	assert.False(t, *networkOVNChassis)
+	assert.Zero(t, networkOVNChassisRestartFailures)
+	networkOVNChassisMu.Unlock()
+}
+
+// Test that no lifecycle event is emitted once the restart retries are exhausted.
+func TestNetworkUpdateOVNChassis_RestartGiveUp(t *testing.T) {
+	s, o := setupOVNChassisTest(t)
+
+	networkOVNChassisRestart = func(s *state.State) error {
+		o.mu.Lock()
+		defer o.mu.Unlock()
+
+		o.restarts++
+		return errors.New("Restart failed")
+	}
+
+	oldMaxRetries := networkOVNChassisRestartMaxRetries
+	networkOVNChassisRestartMaxRetries = 0
+	t.Cleanup(func() { networkOVNChassisRestartMaxRetries = oldMaxRetries })
+
+	require.NoError(t, networkUpdateOVNChassis(s, ovnChassisHeartbeat(ovnChassisLocalAddress), ovnChassisLocalAddress))
+	require.NoError(t, networkUpdateOVNChassis(s, ovnChassisHeartbeat("10.0.0.2:8443"), ovnChassisLocalAddress))
+	require.Eventually(t, func() bool { return o.restartCount() == 1 }, time.Second, 10*time.Millisecond)
+
+	time.Sleep(networkOVNChassisRestartDelay)
+	assert.Empty(t, o.lifecycleEvents())
+}
+
+// Test that the retry backoff is reset once the state goes back to what's applied.
+func TestNetworkUpdateOVNChassis_RestartRetryReset(t *testing.T) {
+	s, o := setupOVNChassisTest(t)
+
+	networkOVNChassisRestart = func(s *state.State) error {
+		o.mu.Lock()
+		defer o.mu.Unlock()
+
+		o.restarts++
+		return errors.New("Restart failed")
+	}
+
+	require.NoError(t, networkUpdateOVNChassis(s, ovnChassisHeartbeat(ovnChassisLocalAddress), ovnChassisLocalAddress))
+
+	require.NoError(t, networkUpdateOVNChassis(s, ovnChassisHeartbeat("10.0.0.2:8443"), ovnChassisLocalAddress))
+	require.Eventually(t, func() bool {
+		networkOVNChassisMu.Lock()
+		defer networkOVNChassisMu.Unlock()
+
+		return networkOVNChassisRestartFailures == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// Reverting to the applied state clears the failure count.
+	require.NoError(t, networkUpdateOVNChassis(s, ovnChassisHeartbeat(ovnChassisLocalAddress), ovnChassisLocalAddress))
+
+	networkOVNChassisMu.Lock()
+	assert.Zero(t, networkOVNChassisRestartFailures)
+	networkOVNChassisMu.Unlock()
+}
+
+// Test that the OVN chassis metrics reflect the roles in the last heartbeat.
+func TestNetworkUpdateOVNChassis_Metrics(t *testing.T) {
+	s, _ := setupOVNChassisTest(t)
+
+	assert.Nil(t, networkOVNChassisMetrics())
+
+	hb := ovnChassisHeartbeat(ovnChassisLocalAddress, "10.0.0.2:8443")
+	hb.Members[3] = cluster.APIHeartbeatMember{ID: 3, Address: "10.0.0.3:8443", Name: "node3"}
+
+	require.NoError(t, networkUpdateOVNChassis(s, hb, ovnChassisLocalAddress))
+
+	out := networkOVNChassisMetrics().String()
+	assert.True(t, strings.Contains(out, "incus_ovn_chassis_members 2\n"), out)
+	assert.True(t, strings.Contains(out, "incus_ovn_chassis_local 1\n"), out)
+
+	require.NoError(t, networkUpdateOVNChassis(s, ovnChassisHeartbeat("10.0.0.2:8443"), ovnChassisLocalAddress))
+
+	out = networkOVNChassisMetrics().String()
+	assert.True(t, strings.Contains(out, "incus_ovn_chassis_members 1\n"), out)
+	assert.True(t, strings.Contains(out, "incus_ovn_chassis_local 0\n"), out)
+}
@@ -203,6 +203,36 @@ func (c *Config) InstancesPlacementScriptlet() string {
 	return c.m.GetString("instances.placement.scriptlet")
 }
 
+// InstancesPlacementScriptletConnectRetries returns how many times the instances placement scriptlet retries connecting to a remote member.
+func (c *Config) InstancesPlacementScriptletConnectRetries() int {
+	return int(c.m.GetInt64("instances.placement.scriptlet.connect_retries"))
+}
+
+// InstancesPlacementScriptletHiddenKeys returns the instance configuration keys hidden from the instances placement scriptlet.
+func (c *Config) InstancesPlacementScriptletHiddenKeys() []string {
+	if c.m.GetString("instances.placement.scriptlet.hidden_keys") == "" {
+		return nil
+	}
+
+	return strings.Split(c.m.GetString("instances.placement.scriptlet.hidden_keys"), ",")
+}
+
+// InstancesPlacementScriptletSkipSingleCandidate returns whether the instances placement scriptlet is skipped when there's a single candidate member.
+func (c *Config) InstancesPlacementScriptletSkipSingleCandidate() bool {
+	return c.m.GetBool("instances.placement.scriptlet.skip_single_candidate")
+}
+
+// InstancesPlacementScriptletTimeout returns how long the instances placement scriptlet may run before being cancelled.
+func (c *Config) InstancesPlacementScriptletTimeout() time.Duration {
+	return time.Duration(c.m.GetInt64("instances.placement.scriptlet.timeout")) * time.Second
+}
+
+// InstancesPlacementScriptletVMDefaults returns the CPU count, memory and root disk size assumed by the instances placement scriptlet for virtual machines.
+// Empty values mean that the built-in defaults apply.
+func (c *Config) InstancesPlacementScriptletVMDefaults() (string, string, string) {
+	return c.m.GetString("instances.placement.scriptlet.vm_default_cpu"), c.m.GetString("instances.placement.scriptlet.vm_default_memory"), c.m.GetString("instances.placement.scriptlet.vm_default_root_disk_size")
+}
+
 // AuthorizationScriptlet returns the authorization scriptlet source code.
 func (c *Config) AuthorizationScriptlet() string {
 	return c.m.GetString("authorization.scriptlet")
@@ -653,6 +683,68 @@ var ConfigSchema = config.Schema{
 	//  shortdesc: Instance placement scriptlet for automatic instance placement
 	"instances.placement.scriptlet": {Validator: validate.Optional(scriptletLoad.InstancePlacementValidate)},
 
+	// gendoc:generate(entity=server, group=miscellaneous, key=instances.placement.scriptlet.connect_retries)
+	// Specify how many times the instance placement scriptlet retries connecting to a remote cluster member after a failure, with a short delay between attempts, before reporting the error.
+	// ---
+	//  type: integer
+	//  scope: global
+	//  defaultdesc: `2`
+	//  shortdesc: Number of connection retries to remote members for the instance placement scriptlet
+	"instances.placement.scriptlet.connect_retries": {Type: config.Int64, Default: "2", Validator: validate.IsInRange(0, 10)},
+
+	// gendoc:generate(entity=server, group=miscellaneous, key=instances.placement.scriptlet.hidden_keys)
+	// Specify a comma-separated list of instance configuration keys that aren't passed to the instance placement scriptlet.
+	// Entries ending with `*` match all keys starting with the given prefix (for example `cloud-init.*`).
+	// By default, the scriptlet can read the full configuration of instances, including any credentials stored in it.
+	// ---
+	//  type: string
+	//  scope: global
+	//  shortdesc: Instance configuration keys hidden from the instance placement scriptlet
+	"instances.placement.scriptlet.hidden_keys": {},
+
+	// gendoc:generate(entity=server, group=miscellaneous, key=instances.placement.scriptlet.skip_single_candidate)
+	// When enabled, the instance placement scriptlet isn't called if only one cluster member is a candidate, and that member is picked directly.
+	// A scriptlet can opt out of this by setting a global `always_run = True`.
+	// ---
+	//  type: bool
+	//  scope: global
+	//  defaultdesc: `false`
+	//  shortdesc: Whether to skip the instance placement scriptlet with a single candidate member
+	"instances.placement.scriptlet.skip_single_candidate": {Type: config.Bool, Default: "false"},
+
+	// gendoc:generate(entity=server, group=miscellaneous, key=instances.placement.scriptlet.timeout)
+	// Specify the number of seconds after which a running instance placement scriptlet is cancelled, failing the placement.
+	// ---
+	//  type: integer
+	//  scope: global
+	//  defaultdesc: `30`
+	//  shortdesc: Timeout for the instance placement scriptlet
+	"instances.placement.scriptlet.timeout": {Type: config.Int64, Default: "30", Validator: validate.IsInRange(1, 3600)},
+
+	// gendoc:generate(entity=server, group=miscellaneous, key=instances.placement.scriptlet.vm_default_cpu)
+	// When set, `get_instance_resources` and `member_fits` use this CPU count for virtual machines that don't set `limits.cpu`, instead of the built-in default.
+	// ---
+	//  type: string
+	//  scope: global
+	//  shortdesc: Number of CPUs assumed by the instance placement scriptlet for virtual machines without `limits.cpu`
+	"instances.placement.scriptlet.vm_default_cpu": {Validator: validate.Optional(validate.IsUint32)},
+
+	// gendoc:generate(entity=server, group=miscellaneous, key=instances.placement.scriptlet.vm_default_memory)
+	// When set, `get_instance_resources` and `member_fits` use this memory size for virtual machines that don't set `limits.memory`, instead of the built-in default.
+	// ---
+	//  type: string
+	//  scope: global
+	//  shortdesc: Memory assumed by the instance placement scriptlet for virtual machines without `limits.memory`
+	"instances.placement.scriptlet.vm_default_memory": {Validator: validate.Optional(validate.IsSize)},
+
+	// gendoc:generate(entity=server, group=miscellaneous, key=instances.placement.scriptlet.vm_default_root_disk_size)
+	// When set, `get_instance_resources` and `member_fits` use this root disk size for virtual machines whose root disk doesn't set `size`, instead of the built-in default.
+	// ---
+	//  type: string
+	//  scope: global
+	//  shortdesc: Root disk size assumed by the instance placement scriptlet for virtual machines without a root disk `size`
+	"instances.placement.scriptlet.vm_default_root_disk_size": {Validator: validate.Optional(validate.IsSize)},
+
 	// gendoc:generate(entity=server, group=loki, key=loki.auth.username)
 	//
 	// ---
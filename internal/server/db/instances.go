@@ -1203,3 +1203,241 @@ func (c *ClusterTx) GetInstancesCount(ctx context.Context, projectName string, l
 
 	return count, nil
 }
+
+// GetInstancesCountBy returns the number of instances grouped by type ("type") or by last known power state
+// ("state") with possible filtering for project or location.
+// When includePending is set, instances currently being created are counted under the "pending" key.
+func (c *ClusterTx) GetInstancesCountBy(ctx context.Context, projectName string, locationName string, includePending bool, groupBy string) (map[string]int, error) {
+	var column string
+
+	switch groupBy {
+	case "type":
+		column = fmt.Sprintf("CASE WHEN instances.type = %d THEN '%s' ELSE '%s' END", instancetype.VM, instancetype.VM.String(), instancetype.Container.String())
+	case "state":
+		column = "CASE WHEN instances_config.value = 'RUNNING' THEN 'running' ELSE 'stopped' END"
+	default:
+		return nil, fmt.Errorf("Invalid instance count grouping %q", groupBy)
+	}
+
+	args := make([]any, 0, 2) // Expect up to 2 filters.
+	filters := make([]string, 0, 2)
+
+	if projectName != "" {
+		filters = append(filters, "projects.name = ?")
+		args = append(args, projectName)
+	}
+
+	if locationName != "" {
+		filters = append(filters, "nodes.name = ?")
+		args = append(args, locationName)
+	}
+
+	where := ""
+	if len(filters) > 0 {
+		where = "WHERE " + strings.Join(filters, " AND ")
+	}
+
+	stmt := fmt.Sprintf(`
+SELECT %s, count(*)
+  FROM instances
+  JOIN projects ON projects.id = instances.project_id
+  JOIN nodes ON nodes.id = instances.node_id
+  LEFT JOIN instances_config ON instances_config.instance_id = instances.id AND instances_config.key = 'volatile.last_state.power'
+ %s
+ GROUP BY 1
+`, column, where)
+
+	counts := map[string]int{}
+	err := query.Scan(ctx, c.tx, stmt, func(scan func(dest ...any) error) error {
+		var key string
+		var count int
+
+		err := scan(&key, &count)
+		if err != nil {
+			return err
+		}
+
+		counts[key] = count
+
+		return nil
+	}, args...)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get instances count: %w", err)
+	}
+
+	if includePending {
+		pendingFilters := append([]string{"operations.type = ?"}, filters...)
+		pendingArgs := append([]any{operationtype.InstanceCreate}, args...)
+
+		stmt := fmt.Sprintf(`
+SELECT count(*)
+  FROM operations
+  LEFT JOIN projects ON projects.id = operations.project_id
+  JOIN nodes ON nodes.id = operations.node_id
+ WHERE %s
+`, strings.Join(pendingFilters, " AND "))
+
+		var pending int
+		err := c.tx.QueryRowContext(ctx, stmt, pendingArgs...).Scan(&pending)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to get pending instances count: %w", err)
+		}
+
+		counts["pending"] = pending
+	}
+
+	return counts, nil
+}
+
+// GetInstancesCountByMember returns the number of instances on each cluster member with possible filtering for
+// project, instance type and configuration key. An empty configValue matches any value of configKey.
+// Members without any matching instance are omitted.
+func (c *ClusterTx) GetInstancesCountByMember(ctx context.Context, projectName string, instanceType instancetype.Type, configKey string, configValue string) (map[string]int, error) {
+	args := make([]any, 0, 4) // Expect up to 4 filters.
+	filters := make([]string, 0, 3)
+	join := ""
+
+	if configKey != "" {
+		join = "JOIN instances_config ON instances_config.instance_id = instances.id AND instances_config.key = ?"
+		args = append(args, configKey)
+
+		if configValue != "" {
+			filters = append(filters, "instances_config.value = ?")
+			args = append(args, configValue)
+		}
+	}
+
+	if projectName != "" {
+		filters = append(filters, "projects.name = ?")
+		args = append(args, projectName)
+	}
+
+	if instanceType != instancetype.Any {
+		filters = append(filters, "instances.type = ?")
+		args = append(args, instanceType)
+	}
+
+	where := ""
+	if len(filters) > 0 {
+		where = "WHERE " + strings.Join(filters, " AND ")
+	}
+
+	stmt := fmt.Sprintf(`
+SELECT nodes.name, count(*)
+  FROM instances
+  JOIN projects ON projects.id = instances.project_id
+  JOIN nodes ON nodes.id = instances.node_id
+  %s
+ %s
+ GROUP BY nodes.name
+`, join, where)
+
+	counts := map[string]int{}
+	err := query.Scan(ctx, c.tx, stmt, func(scan func(dest ...any) error) error {
+		var memberName string
+		var count int
+
+		err := scan(&memberName, &count)
+		if err != nil {
+			return err
+		}
+
+		counts[memberName] = count
+
+		return nil
+	}, args...)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get instances count by member: %w", err)
+	}
+
+	return counts, nil
+}
+
+// GetInstancesByMember returns the instances located on the given cluster member across all projects.
+// When runningOnly is set, only the instances whose last known power state is running are returned.
+func (c *ClusterTx) GetInstancesByMember(ctx context.Context, memberName string, runningOnly bool) ([]Instance, error) {
+	args := []any{memberName}
+	filters := []string{"nodes.name = ?"}
+
+	if runningOnly {
+		filters = append(filters, "instances_config.value = ?")
+		args = append(args, "RUNNING")
+	}
+
+	stmt := fmt.Sprintf(`
+SELECT instances.id, instances.name, instances.type, nodes.name, projects.name
+  FROM instances
+  JOIN projects ON projects.id = instances.project_id
+  JOIN nodes ON nodes.id = instances.node_id
+  LEFT JOIN instances_config ON instances_config.instance_id = instances.id AND instances_config.key = 'volatile.last_state.power'
+ WHERE %s
+ ORDER BY projects.name, instances.name
+`, strings.Join(filters, " AND "))
+
+	instances := []Instance{}
+	err := query.Scan(ctx, c.tx, stmt, func(scan func(dest ...any) error) error {
+		var inst Instance
+
+		err := scan(&inst.ID, &inst.Name, &inst.Type, &inst.Location, &inst.Project)
+		if err != nil {
+			return err
+		}
+
+		instances = append(instances, inst)
+
+		return nil
+	}, args...)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get instances of member %q: %w", memberName, err)
+	}
+
+	return instances, nil
+}
+
+// GetPendingInstances returns the instances currently being created for which no database record exists yet.
+// Only the project and location of those instances are known.
+func (c *ClusterTx) GetPendingInstances(ctx context.Context, projectName string, locationName string) ([]api.Instance, error) {
+	args := []any{operationtype.InstanceCreate}
+	filters := []string{"operations.type = ?"}
+
+	if projectName != "" {
+		filters = append(filters, "projects.name = ?")
+		args = append(args, projectName)
+	}
+
+	if locationName != "" {
+		filters = append(filters, "nodes.name = ?")
+		args = append(args, locationName)
+	}
+
+	stmt := fmt.Sprintf(`
+SELECT coalesce(projects.name, ''), nodes.name
+  FROM operations
+  LEFT JOIN projects ON projects.id = operations.project_id
+  JOIN nodes ON nodes.id = operations.node_id
+ WHERE %s
+ ORDER BY operations.id
+`, strings.Join(filters, " AND "))
+
+	instances := []api.Instance{}
+	err := query.Scan(ctx, c.tx, stmt, func(scan func(dest ...any) error) error {
+		inst := api.Instance{
+			Status:     api.Pending.String(),
+			StatusCode: api.Pending,
+		}
+
+		err := scan(&inst.Project, &inst.Location)
+		if err != nil {
+			return err
+		}
+
+		instances = append(instances, inst)
+
+		return nil
+	}, args...)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get pending instances: %w", err)
+	}
+
+	return instances, nil
+}
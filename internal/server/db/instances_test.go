@@ -13,6 +13,7 @@ import (
 
 	"github.com/lxc/incus/v6/internal/server/db"
 	"github.com/lxc/incus/v6/internal/server/db/cluster"
+	"github.com/lxc/incus/v6/internal/server/db/operationtype"
 	"github.com/lxc/incus/v6/internal/server/instance/instancetype"
 	"github.com/lxc/incus/v6/shared/api"
 )
@@ -546,6 +547,136 @@ func TestGetLocalInstancesInProject(t *testing.T) {
 	assert.Equal(t, map[string]map[string]string{"root": {"type": "disk", "x": "y"}}, cluster.DevicesToAPI(c3Devices))
 }
 
+func TestGetInstancesCountBy(t *testing.T) {
+	tx, cleanup := db.NewTestClusterTx(t)
+	defer cleanup()
+
+	nodeID, err := tx.CreateNode("node2", "2.2.2.2:8443")
+	require.NoError(t, err)
+
+	addContainer(t, tx, nodeID, "c1")
+	addContainer(t, tx, nodeID, "c2")
+	addContainer(t, tx, 1, "c3")
+	addContainerConfig(t, tx, "c1", "volatile.last_state.power", "RUNNING")
+	addContainerConfig(t, tx, "c2", "volatile.last_state.power", "STOPPED")
+
+	_, err = tx.Tx().Exec("UPDATE instances SET type = ? WHERE name = 'c3'", instancetype.VM)
+	require.NoError(t, err)
+
+	counts, err := tx.GetInstancesCountBy(context.Background(), "", "", false, "type")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"container": 2, "virtual-machine": 1}, counts)
+
+	counts, err = tx.GetInstancesCountBy(context.Background(), "default", "node2", false, "state")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"running": 1, "stopped": 1}, counts)
+
+	counts, err = tx.GetInstancesCountBy(context.Background(), "", "none", true, "state")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"stopped": 1, "pending": 0}, counts)
+
+	_, err = tx.GetInstancesCountBy(context.Background(), "", "", false, "color")
+	assert.Error(t, err)
+}
+
+func TestGetInstancesCountByMember(t *testing.T) {
+	tx, cleanup := db.NewTestClusterTx(t)
+	defer cleanup()
+
+	nodeID, err := tx.CreateNode("node2", "2.2.2.2:8443")
+	require.NoError(t, err)
+
+	addContainer(t, tx, nodeID, "c1")
+	addContainer(t, tx, nodeID, "c2")
+	addContainer(t, tx, 1, "c3")
+	addContainerConfig(t, tx, "c1", "user.tier", "web")
+	addContainerConfig(t, tx, "c2", "user.tier", "db")
+	addContainerConfig(t, tx, "c3", "user.tier", "web")
+
+	_, err = tx.Tx().Exec("UPDATE instances SET type = ? WHERE name = 'c3'", instancetype.VM)
+	require.NoError(t, err)
+
+	counts, err := tx.GetInstancesCountByMember(context.Background(), "", instancetype.Any, "", "")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"node2": 2, "none": 1}, counts)
+
+	counts, err = tx.GetInstancesCountByMember(context.Background(), "default", instancetype.Container, "", "")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"node2": 2}, counts)
+
+	counts, err = tx.GetInstancesCountByMember(context.Background(), "", instancetype.Any, "user.tier", "web")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"node2": 1, "none": 1}, counts)
+
+	counts, err = tx.GetInstancesCountByMember(context.Background(), "", instancetype.Any, "user.tier", "")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"node2": 2, "none": 1}, counts)
+
+	counts, err = tx.GetInstancesCountByMember(context.Background(), "", instancetype.Any, "user.rack", "")
+	require.NoError(t, err)
+	assert.Empty(t, counts)
+}
+
+func TestGetInstancesByMember(t *testing.T) {
+	tx, cleanup := db.NewTestClusterTx(t)
+	defer cleanup()
+
+	nodeID, err := tx.CreateNode("node2", "2.2.2.2:8443")
+	require.NoError(t, err)
+
+	addContainer(t, tx, nodeID, "c1")
+	addContainer(t, tx, nodeID, "c2")
+	addContainer(t, tx, nodeID, "c3")
+	addContainer(t, tx, 1, "c4")
+	addContainerConfig(t, tx, "c1", "volatile.last_state.power", "RUNNING")
+	addContainerConfig(t, tx, "c2", "volatile.last_state.power", "STOPPED")
+	addContainerConfig(t, tx, "c4", "volatile.last_state.power", "RUNNING")
+
+	instances, err := tx.GetInstancesByMember(context.Background(), "node2", false)
+	require.NoError(t, err)
+	require.Len(t, instances, 3)
+	assert.Equal(t, "c1", instances[0].Name)
+	assert.Equal(t, "c2", instances[1].Name)
+	assert.Equal(t, "c3", instances[2].Name)
+	assert.Equal(t, "default", instances[0].Project)
+	assert.Equal(t, "node2", instances[0].Location)
+
+	instances, err = tx.GetInstancesByMember(context.Background(), "node2", true)
+	require.NoError(t, err)
+	require.Len(t, instances, 1)
+	assert.Equal(t, "c1", instances[0].Name)
+
+	instances, err = tx.GetInstancesByMember(context.Background(), "node3", false)
+	require.NoError(t, err)
+	assert.Empty(t, instances)
+}
+
+func TestGetPendingInstances(t *testing.T) {
+	tx, cleanup := db.NewTestClusterTx(t)
+	defer cleanup()
+
+	nodeID, err := tx.CreateNode("node2", "2.2.2.2:8443")
+	require.NoError(t, err)
+
+	projectID := int64(1)
+	_, err = cluster.CreateOrReplaceOperation(context.Background(), tx.Tx(), cluster.Operation{UUID: "op1", NodeID: nodeID, ProjectID: &projectID, Type: operationtype.InstanceCreate})
+	require.NoError(t, err)
+
+	_, err = cluster.CreateOrReplaceOperation(context.Background(), tx.Tx(), cluster.Operation{UUID: "op2", NodeID: nodeID, ProjectID: &projectID, Type: operationtype.InstanceDelete})
+	require.NoError(t, err)
+
+	instances, err := tx.GetPendingInstances(context.Background(), "", "")
+	require.NoError(t, err)
+	require.Len(t, instances, 1)
+	assert.Equal(t, "default", instances[0].Project)
+	assert.Equal(t, "node2", instances[0].Location)
+	assert.Equal(t, api.Pending, instances[0].StatusCode)
+
+	instances, err = tx.GetPendingInstances(context.Background(), "default", "none")
+	require.NoError(t, err)
+	assert.Empty(t, instances)
+}
+
 func addContainer(t *testing.T, tx *db.ClusterTx, nodeID int64, name string) {
 	stmt := `
 INSERT INTO instances(node_id, name, architecture, type, project_id, description) VALUES (?, ?, 1, ?, 1, '')
@@ -183,6 +183,26 @@ func ExpandInstanceConfig(config map[string]string, profiles []api.Profile) map[
 	return expandedConfig
 }
 
+// ExpandInstanceConfigSources returns, for each key of the expanded instance config, the name of the
+// profile it comes from, or an empty string if it's set on the instance itself.
+func ExpandInstanceConfigSources(config map[string]string, profiles []api.Profile) map[string]string {
+	sources := map[string]string{}
+
+	// Later profiles override earlier ones.
+	for _, profile := range profiles {
+		for k := range profile.Config {
+			sources[k] = profile.Name
+		}
+	}
+
+	// The instance config overrides all profiles.
+	for k := range config {
+		sources[k] = ""
+	}
+
+	return sources
+}
+
 // ExpandInstanceDevices expands the given instance devices with the devices
 // defined in the given profiles.
 func ExpandInstanceDevices(devices deviceConfig.Devices, profiles []api.Profile) deviceConfig.Devices {
@@ -0,0 +1,28 @@
+package lifecycle
+
+import (
+	"github.com/lxc/incus/v6/internal/version"
+	"github.com/lxc/incus/v6/shared/api"
+)
+
+// InstancePlacementAction represents a lifecycle event action for instance placement.
+type InstancePlacementAction string
+
+// All supported lifecycle events for instance placement.
+const (
+	InstancePlaced = InstancePlacementAction(api.EventLifecycleInstancePlaced)
+)
+
+// Event creates the lifecycle event for the placement of an instance.
+// The instance may not exist yet when it is being placed, so only its name and project are used.
+func (a InstancePlacementAction) Event(name string, projectName string, ctx map[string]any) api.EventLifecycle {
+	u := api.NewURL().Path(version.APIVersion, "instances", name).Project(projectName)
+
+	return api.EventLifecycle{
+		Action:  string(a),
+		Source:  u.String(),
+		Context: ctx,
+		Name:    name,
+		Project: projectName,
+	}
+}
@@ -0,0 +1,49 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	apiScriptlet "github.com/lxc/incus/v6/shared/api/scriptlet"
+)
+
+// MemberHistorySize is the maximum number of samples kept in the member history.
+const MemberHistorySize = 60
+
+// MemberHistoryInterval is the interval at which the local member resource usage is sampled.
+const MemberHistoryInterval = time.Minute
+
+// MemberHistory keeps the most recent resource usage samples of a cluster member.
+type MemberHistory struct {
+	mu      sync.Mutex
+	samples []apiScriptlet.MemberMetricsSample
+}
+
+// LocalMemberHistory holds the resource usage samples of the local cluster member.
+var LocalMemberHistory = &MemberHistory{}
+
+// Add records a new sample, dropping the oldest one if the history is full.
+func (h *MemberHistory) Add(sample apiScriptlet.MemberMetricsSample) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.samples = append(h.samples, sample)
+	if len(h.samples) > MemberHistorySize {
+		h.samples = h.samples[len(h.samples)-MemberHistorySize:]
+	}
+}
+
+// Since returns the samples taken at or after the given time, oldest first.
+func (h *MemberHistory) Since(since time.Time) []apiScriptlet.MemberMetricsSample {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	samples := []apiScriptlet.MemberMetricsSample{}
+	for _, sample := range h.samples {
+		if sample.Timestamp >= since.Unix() {
+			samples = append(samples, sample)
+		}
+	}
+
+	return samples
+}
@@ -0,0 +1,33 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	apiScriptlet "github.com/lxc/incus/v6/shared/api/scriptlet"
+)
+
+func TestMemberHistory(t *testing.T) {
+	h := &MemberHistory{}
+	now := time.Now()
+
+	for i := MemberHistorySize + 10; i > 0; i-- {
+		h.Add(apiScriptlet.MemberMetricsSample{Timestamp: now.Add(-time.Duration(i) * time.Minute).Unix(), LoadAverage: float64(i)})
+	}
+
+	// The history is bounded.
+	samples := h.Since(time.Time{})
+	require.Len(t, samples, MemberHistorySize)
+	assert.Equal(t, float64(MemberHistorySize), samples[0].LoadAverage)
+
+	// Only samples within the window are returned.
+	samples = h.Since(now.Add(-5 * time.Minute))
+	require.Len(t, samples, 5)
+	assert.Equal(t, float64(5), samples[0].LoadAverage)
+	assert.Equal(t, float64(1), samples[4].LoadAverage)
+
+	assert.Empty(t, h.Since(now))
+}
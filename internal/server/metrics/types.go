@@ -144,6 +144,10 @@ const (
 	GoOtherSysBytes
 	// GoNextGCBytes represents the number of heap bytes when next garbage collection will take place.
 	GoNextGCBytes
+	// OVNChassisMembers represents the number of cluster members with the OVN chassis role.
+	OVNChassisMembers
+	// OVNChassisLocal represents whether the local member is running as an OVN chassis.
+	OVNChassisLocal
 )
 
 // MetricNames associates a metric type to its name.
@@ -209,6 +213,8 @@ var MetricNames = map[MetricType]string{
 	NetworkTransmitErrsTotal:    "incus_network_transmit_errs_total",
 	NetworkTransmitPacketsTotal: "incus_network_transmit_packets_total",
 	OperationsTotal:             "incus_operations_total",
+	OVNChassisLocal:             "incus_ovn_chassis_local",
+	OVNChassisMembers:           "incus_ovn_chassis_members",
 	ProcsTotal:                  "incus_procs_total",
 	UptimeSeconds:               "incus_uptime_seconds",
 	WarningsTotal:               "incus_warnings_total",
@@ -277,6 +283,8 @@ var MetricHeaders = map[MetricType]string{
 	NetworkTransmitErrsTotal:    "# HELP incus_network_transmit_errs_total The amount of transmitted errors on a given interface.",
 	NetworkTransmitPacketsTotal: "# HELP incus_network_transmit_packets_total The amount of transmitted packets on a given interface.",
 	OperationsTotal:             "# HELP incus_operations_total The number of running operations",
+	OVNChassisLocal:             "# HELP incus_ovn_chassis_local Whether the local member is running as an OVN chassis.",
+	OVNChassisMembers:           "# HELP incus_ovn_chassis_members The number of cluster members with the OVN chassis role.",
 	ProcsTotal:                  "# HELP incus_procs_total The number of running processes.",
 	UptimeSeconds:               "# HELP incus_uptime_seconds The daemon uptime in seconds.",
 	WarningsTotal:               "# HELP incus_warnings_total The number of active warnings.",
@@ -2,289 +2,2626 @@ package scriptlet
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/fnv"
+	"maps"
+	"math"
+	"math/rand"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
 
 	"go.starlark.net/starlark"
 
+	incus "github.com/lxc/incus/v6/client"
+	localInstance "github.com/lxc/incus/v6/internal/instance"
 	"github.com/lxc/incus/v6/internal/server/cluster"
 	"github.com/lxc/incus/v6/internal/server/db"
 	dbCluster "github.com/lxc/incus/v6/internal/server/db/cluster"
+	"github.com/lxc/incus/v6/internal/server/db/warningtype"
 	internalInstance "github.com/lxc/incus/v6/internal/server/instance"
+	"github.com/lxc/incus/v6/internal/server/instance/instancetype"
+	"github.com/lxc/incus/v6/internal/server/lifecycle"
+	"github.com/lxc/incus/v6/internal/server/metrics"
+	"github.com/lxc/incus/v6/internal/server/project"
 	"github.com/lxc/incus/v6/internal/server/resources"
 	scriptletLoad "github.com/lxc/incus/v6/internal/server/scriptlet/load"
 	"github.com/lxc/incus/v6/internal/server/scriptlet/log"
 	"github.com/lxc/incus/v6/internal/server/scriptlet/marshal"
 	"github.com/lxc/incus/v6/internal/server/state"
+	storagePools "github.com/lxc/incus/v6/internal/server/storage"
 	"github.com/lxc/incus/v6/shared/api"
 	apiScriptlet "github.com/lxc/incus/v6/shared/api/scriptlet"
 	"github.com/lxc/incus/v6/shared/logger"
+	"github.com/lxc/incus/v6/shared/osarch"
+	"github.com/lxc/incus/v6/shared/units"
 )
 
+// ErrInstancePlacementRejected is returned when the instance placement scriptlet rejects the placement.
+type ErrInstancePlacementRejected struct {
+	Message string
+}
+
+func (e ErrInstancePlacementRejected) Error() string {
+	return fmt.Sprintf("Placement rejected: %s", e.Message)
+}
+
+// ErrInstancePlacementRetry is returned when the instance placement scriptlet asks to be run again after a delay.
+type ErrInstancePlacementRetry struct {
+	After time.Duration
+}
+
+func (e ErrInstancePlacementRetry) Error() string {
+	return fmt.Sprintf("Placement retry requested after %s", e.After)
+}
+
+// instancePlacementMaxRetries is the number of times InstancePlacementRunWithRetry honors a retry request.
+var instancePlacementMaxRetries = 3
+
+// instancePlacementRetryWait waits before running the scriptlet again, returning early if the context is done.
+var instancePlacementRetryWait = func(ctx context.Context, after time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(after):
+		return nil
+	}
+}
+
+// instancePlacementConfigOverrideKeys are the instance configuration keys the scriptlet is allowed to override
+// (in addition to user.* keys).
+var instancePlacementConfigOverrideKeys = []string{
+	"boot.autostart",
+	"boot.autostart.delay",
+	"boot.autostart.priority",
+	"cluster.evacuate",
+	"limits.cpu.priority",
+	"limits.disk.priority",
+}
+
+// instancePlacementSeed returns the seed of the random number generator used by a placement scriptlet run.
+var instancePlacementSeed = func() int64 {
+	return time.Now().UnixNano()
+}
+
+// instancePlacementNow returns the current time as seen by a placement scriptlet run.
+var instancePlacementNow = time.Now
+
+// instancePlacementConnect connects to a remote cluster member on behalf of a placement scriptlet run.
+var instancePlacementConnect = func(s *state.State, member db.NodeInfo) (incus.InstanceServer, error) {
+	return cluster.Connect(member.Address, s.Endpoints.NetworkCert(), s.ServerCert(), nil, true)
+}
+
+// instancePlacementConnectRetryDelay is the delay before the first connection retry, growing with each attempt.
+var instancePlacementConnectRetryDelay = 250 * time.Millisecond
+
+// instancePlacementConnectWithRetry connects to a remote cluster member, retrying up to the given number of times
+// on failure. Retries stop as soon as the context is done, returning the last connection error.
+func instancePlacementConnectWithRetry(ctx context.Context, s *state.State, member db.NodeInfo, retries int) (incus.InstanceServer, error) {
+	for attempt := 0; ; attempt++ {
+		client, err := instancePlacementConnect(s, member)
+		if err == nil {
+			return client, nil
+		}
+
+		if attempt >= retries {
+			return nil, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(instancePlacementConnectRetryDelay * time.Duration(attempt+1)):
+		}
+	}
+}
+
+// InstancePlacementResult represents the outcome of the instance placement scriptlet.
+type InstancePlacementResult struct {
+	// Member is the cluster member selected by the scriptlet, nil if none was selected.
+	Member *db.NodeInfo
+
+	// ConfigOverrides are the instance configuration keys set by the scriptlet.
+	ConfigOverrides map[string]string
+
+	// Pool is the storage pool selected by the scriptlet for the root disk, empty if none was selected.
+	Pool string
+
+	// RemoteErrors are the errors fetching data from remote members that were ignored by the scriptlet.
+	RemoteErrors []error
+
+	// Targets are the member and storage pool pairs selected by the scriptlet, in the order they should be tried.
+	// The first one matches Member and Pool.
+	Targets []InstancePlacementTarget
+}
+
+// InstancePlacementTarget represents a cluster member and storage pool selected by the instance placement scriptlet.
+type InstancePlacementTarget struct {
+	// Member is the selected cluster member.
+	Member *db.NodeInfo
+
+	// Pool is the storage pool selected for the root disk, empty if none was selected.
+	Pool string
+}
+
+// instancePlacementMemberGPUs returns a compact list of the GPU cards found in a member's resources.
+func instancePlacementMemberGPUs(res *api.Resources) []apiScriptlet.MemberGPU {
+	gpus := make([]apiScriptlet.MemberGPU, 0, len(res.GPU.Cards))
+	for _, card := range res.GPU.Cards {
+		gpu := apiScriptlet.MemberGPU{
+			PCIAddress: card.PCIAddress,
+			Vendor:     card.Vendor,
+			VendorID:   card.VendorID,
+			Product:    card.Product,
+			ProductID:  card.ProductID,
+			Driver:     card.Driver,
+			NUMANode:   card.NUMANode,
+		}
+
+		if card.SRIOV != nil {
+			gpu.VFsCurrent = card.SRIOV.CurrentVFs
+			gpu.VFsMaximum = card.SRIOV.MaximumVFs
+		}
+
+		for _, mdev := range card.Mdev {
+			gpu.MdevAvailable += mdev.Available
+		}
+
+		gpus = append(gpus, gpu)
+	}
+
+	return gpus
+}
+
+// instancePlacementMemberNetworkPorts returns a compact list of the network ports found in a member's resources.
+func instancePlacementMemberNetworkPorts(res *api.Resources) []apiScriptlet.MemberNetworkPort {
+	ports := []apiScriptlet.MemberNetworkPort{}
+	for _, card := range res.Network.Cards {
+		for _, cardPort := range card.Ports {
+			port := apiScriptlet.MemberNetworkPort{
+				ID:           cardPort.ID,
+				Address:      cardPort.Address,
+				PCIAddress:   card.PCIAddress,
+				Driver:       card.Driver,
+				NUMANode:     card.NUMANode,
+				LinkDetected: cardPort.LinkDetected,
+				LinkSpeed:    cardPort.LinkSpeed,
+			}
+
+			if card.SRIOV != nil {
+				port.VFsCurrent = card.SRIOV.CurrentVFs
+				port.VFsMaximum = card.SRIOV.MaximumVFs
+
+				// VFs handed to instances are no longer bound on the host, so they don't show any port.
+				// More VFs get enabled as needed, so any VF up to the maximum that isn't in use is free.
+				var used uint64
+				for _, vf := range card.SRIOV.VFs {
+					if len(vf.Ports) == 0 {
+						used++
+					}
+				}
+
+				port.VFsFree = card.SRIOV.MaximumVFs - min(used, card.SRIOV.MaximumVFs)
+			}
+
+			ports = append(ports, port)
+		}
+	}
+
+	return ports
+}
+
+// instancePlacementMemberHugepages returns the huge pages found in a member's resources, grouped by page size.
+// The resources only report the default huge page size, so there's at most one entry.
+func instancePlacementMemberHugepages(res *api.Resources) []apiScriptlet.MemberHugepages {
+	hugepages := []apiScriptlet.MemberHugepages{}
+
+	size := res.Memory.HugepagesSize
+	if size == 0 || res.Memory.HugepagesTotal == 0 {
+		return hugepages
+	}
+
+	total := res.Memory.HugepagesTotal / size
+	used := min(res.Memory.HugepagesUsed/size, total)
+
+	hugepages = append(hugepages, apiScriptlet.MemberHugepages{
+		PageSize: size,
+		Total:    total,
+		Free:     total - used,
+	})
+
+	return hugepages
+}
+
+// instancePlacementClusterResources returns the sum of the CPU, memory and disk totals of the given member resources.
+func instancePlacementClusterResources(members []*api.Resources) apiScriptlet.ClusterResources {
+	total := apiScriptlet.ClusterResources{}
+
+	for _, res := range members {
+		total.Members++
+		total.CPUTotal += res.CPU.Total
+		total.MemoryTotal += res.Memory.Total
+		total.MemoryUsed += res.Memory.Used
+
+		for _, disk := range res.Storage.Disks {
+			total.StorageTotal += disk.Size
+		}
+	}
+
+	return total
+}
+
+// instancePlacementMemberSupportsMemoryHotplug checks whether a member's resources allow virtual machines with memory hotplug.
+// QEMU only supports hotplugging memory on x86_64 and aarch64, and x86_64 hosts must expose hardware virtualization.
+// Virtualization support isn't visible in the CPU flags on aarch64, so those members are assumed to have it.
+func instancePlacementMemberSupportsMemoryHotplug(res *api.Resources) bool {
+	switch res.CPU.Architecture {
+	case "x86_64":
+		for _, socket := range res.CPU.Sockets {
+			for _, core := range socket.Cores {
+				if slices.Contains(core.Flags, "vmx") || slices.Contains(core.Flags, "svm") {
+					return true
+				}
+			}
+		}
+
+		return false
+	case "aarch64":
+		return true
+	default:
+		return false
+	}
+}
+
+// instancePlacementCPUFlags returns the CPU flags common to all cores of a member.
+func instancePlacementCPUFlags(res *api.Resources) map[string]bool {
+	var flags map[string]bool
+	for _, socket := range res.CPU.Sockets {
+		for _, core := range socket.Cores {
+			coreFlags := make(map[string]bool, len(core.Flags))
+			for _, flag := range core.Flags {
+				if flags == nil || flags[flag] {
+					coreFlags[flag] = true
+				}
+			}
+
+			flags = coreFlags
+		}
+	}
+
+	return flags
+}
+
+// instancePlacementCanLiveMigrate checks whether an instance running on the source member can be live migrated to the target member.
+// The target must have the same architecture and all the CPU flags of the source.
+// Returns an empty reason when migration is possible.
+func instancePlacementCanLiveMigrate(source *api.Resources, target *api.Resources) (bool, string) {
+	if source.CPU.Architecture != target.CPU.Architecture {
+		return false, fmt.Sprintf("Architecture mismatch: %s != %s", source.CPU.Architecture, target.CPU.Architecture)
+	}
+
+	targetFlags := instancePlacementCPUFlags(target)
+
+	var missing []string
+	for flag := range instancePlacementCPUFlags(source) {
+		if !targetFlags[flag] {
+			missing = append(missing, flag)
+		}
+	}
+
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return false, fmt.Sprintf("Missing CPU flags: %s", strings.Join(missing, ", "))
+	}
+
+	return true, ""
+}
+
+// instancePlacementDeviceCounts returns the number of devices of each type.
+func instancePlacementDeviceCounts(devices map[string]map[string]string) map[string]int {
+	counts := map[string]int{}
+	for _, device := range devices {
+		counts[device["type"]]++
+	}
+
+	return counts
+}
+
+// instancePlacementNetworkRequirements returns the aggregated bandwidth limits of the NIC devices.
+// As for the NIC devices themselves, limits.max takes precedence over limits.ingress and limits.egress.
+func instancePlacementNetworkRequirements(devices map[string]map[string]string) (*apiScriptlet.InstanceNetworkRequirements, error) {
+	reqs := &apiScriptlet.InstanceNetworkRequirements{}
+
+	for name, device := range devices {
+		if device["type"] != "nic" {
+			continue
+		}
+
+		reqs.NICs++
+
+		ingress := device["limits.ingress"]
+		egress := device["limits.egress"]
+		if device["limits.max"] != "" {
+			ingress = device["limits.max"]
+			egress = device["limits.max"]
+		}
+
+		if ingress == "" {
+			reqs.UnlimitedIngress++
+		} else {
+			limit, err := units.ParseBitSizeString(ingress)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid ingress limit on device %q: %w", name, err)
+			}
+
+			reqs.Ingress += uint64(limit)
+		}
+
+		if egress == "" {
+			reqs.UnlimitedEgress++
+		} else {
+			limit, err := units.ParseBitSizeString(egress)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid egress limit on device %q: %w", name, err)
+			}
+
+			reqs.Egress += uint64(limit)
+		}
+	}
+
+	return reqs, nil
+}
+
+// instancePlacementFilterConfig returns a copy of config without the hidden keys.
+// Hidden keys ending with "*" match all keys starting with the given prefix.
+func instancePlacementFilterConfig(config map[string]string, hiddenKeys []string) map[string]string {
+	if config == nil || len(hiddenKeys) == 0 {
+		return config
+	}
+
+	filtered := make(map[string]string, len(config))
+	for key, value := range config {
+		hidden := slices.ContainsFunc(hiddenKeys, func(hiddenKey string) bool {
+			prefix, ok := strings.CutSuffix(hiddenKey, "*")
+			if ok {
+				return strings.HasPrefix(key, prefix)
+			}
+
+			return key == hiddenKey
+		})
+
+		if !hidden {
+			filtered[key] = value
+		}
+	}
+
+	return filtered
+}
+
+// instancePlacementLabels returns the labels of an instance from its user.* configuration keys.
+func instancePlacementLabels(config map[string]string) map[string]string {
+	labels := map[string]string{}
+	for key, value := range config {
+		label, ok := strings.CutPrefix(key, "user.")
+		if ok && label != "" {
+			labels[label] = value
+		}
+	}
+
+	return labels
+}
+
+// instancePlacementMemberMaintenance returns whether a cluster member is "evacuated", in "maintenance" or "available".
+// Members which are still joining the cluster or excluded from automatic placement are considered in maintenance.
+func instancePlacementMemberMaintenance(member db.NodeInfo) string {
+	switch {
+	case member.State == db.ClusterMemberStateEvacuated:
+		return "evacuated"
+	case member.State == db.ClusterMemberStatePending, member.Config["scheduler.instance"] == "manual":
+		return "maintenance"
+	default:
+		return "available"
+	}
+}
+
+// instancePlacementMemberSupportsArch checks whether a cluster member can run instances of the given architecture.
+// This is the case if the architecture is the member's own or one of its personalities (e.g. i686 on x86_64).
+func instancePlacementMemberSupportsArch(member db.NodeInfo, arch string) (bool, error) {
+	archID, err := osarch.ArchitectureId(arch)
+	if err != nil {
+		return false, err
+	}
+
+	personalities, err := osarch.ArchitecturePersonalities(member.Architecture)
+	if err != nil {
+		return false, err
+	}
+
+	return archID == member.Architecture || slices.Contains(personalities, archID), nil
+}
+
+// instancePlacementFits checks whether an instance with the given resource usage fits in the free capacity of a member.
+// Returns the first limiting dimension ("cpu", "memory" or "disk"), or an empty string if the instance fits.
+// The disk dimension is only checked when the resources of the instance's root disk pool are provided.
+func instancePlacementFits(usage apiScriptlet.InstanceResources, res *api.Resources, pool *api.ResourcesStoragePool) string {
+	if usage.CPUCores > res.CPU.Total {
+		return "cpu"
+	}
+
+	if res.Memory.Used > res.Memory.Total || usage.MemorySize > res.Memory.Total-res.Memory.Used {
+		return "memory"
+	}
+
+	if pool != nil && (pool.Space.Used > pool.Space.Total || usage.RootDiskSize > pool.Space.Total-pool.Space.Used) {
+		return "disk"
+	}
+
+	return ""
+}
+
+// instancePlacementMemberRoles returns the roles of a cluster member, including its database roles.
+func instancePlacementMemberRoles(member db.NodeInfo, raftNodes []db.RaftNode, leaderAddress string) []string {
+	roles := make([]string, 0, len(member.Roles))
+	for _, role := range member.Roles {
+		roles = append(roles, string(role))
+	}
+
+	if member.Address == leaderAddress {
+		roles = append(roles, string(db.ClusterRoleDatabaseLeader))
+	}
+
+	for _, raftNode := range raftNodes {
+		if raftNode.Address != member.Address {
+			continue
+		}
+
+		switch raftNode.Role {
+		case db.RaftVoter:
+			roles = append(roles, string(db.ClusterRoleDatabase))
+		case db.RaftStandBy:
+			roles = append(roles, string(db.ClusterRoleDatabaseStandBy))
+		}
+
+		break
+	}
+
+	return roles
+}
+
+// instancePlacementOVNChassis returns the names of the members acting as OVN chassis.
+// As with the heartbeat handling, all members act as chassis when none has the role.
+func instancePlacementOVNChassis(members []db.NodeInfo) []string {
+	chassis := []string{}
+	for _, member := range members {
+		if slices.Contains(member.Roles, db.ClusterRoleOVNChassis) {
+			chassis = append(chassis, member.Name)
+		}
+	}
+
+	if len(chassis) > 0 {
+		return chassis
+	}
+
+	for _, member := range members {
+		chassis = append(chassis, member.Name)
+	}
+
+	return chassis
+}
+
+// instancePlacementChooseWeighted picks a name with a probability proportional to its weight.
+func instancePlacementChooseWeighted(rng *rand.Rand, weights map[string]float64) (string, error) {
+	names := make([]string, 0, len(weights))
+	total := 0.0
+	for name, weight := range weights {
+		if weight > 0 {
+			names = append(names, name)
+			total += weight
+		}
+	}
+
+	if total <= 0 {
+		return "", fmt.Errorf("At least one weight must be positive")
+	}
+
+	// Sort the names so the outcome only depends on the random number generator.
+	sort.Strings(names)
+
+	pick := rng.Float64() * total
+	for _, name := range names {
+		pick -= weights[name]
+		if pick < 0 {
+			return name, nil
+		}
+	}
+
+	// Only reachable through floating point rounding.
+	return names[len(names)-1], nil
+}
+
+// instancePlacementBestScore returns the name with the highest score.
+// Ties are broken by picking the first name in alphabetical order.
+func instancePlacementBestScore(scores map[string]float64) string {
+	names := make([]string, 0, len(scores))
+	for name := range scores {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	best := ""
+	for _, name := range names {
+		if best == "" || scores[name] > scores[best] {
+			best = name
+		}
+	}
+
+	return best
+}
+
+// instancePlacementLeastLoaded returns the name with the lowest count, names missing from counts having none.
+// Ties go to the lowest name so that the result is stable.
+func instancePlacementLeastLoaded(counts map[string]int, names []string) string {
+	best := ""
+	for _, name := range names {
+		if best == "" || counts[name] < counts[best] || (counts[name] == counts[best] && name < best) {
+			best = name
+		}
+	}
+
+	return best
+}
+
+// instancePlacementLoadBalance returns the mean and population standard deviation of the given loads.
+// The suggested member is the one where adding its delta to its load leaves the lowest variance, ties going
+// to the lowest name so that the result is stable.
+func instancePlacementLoadBalance(loads map[string]float64, deltas map[string]float64) apiScriptlet.ClusterLoadBalance {
+	result := apiScriptlet.ClusterLoadBalance{Loads: loads}
+	if len(loads) == 0 {
+		return result
+	}
+
+	n := float64(len(loads))
+
+	sum := 0.0
+	for _, load := range loads {
+		sum += load
+	}
+
+	result.Mean = sum / n
+
+	squares := 0.0
+	for _, load := range loads {
+		squares += (load - result.Mean) * (load - result.Mean)
+	}
+
+	result.StdDev = math.Sqrt(squares / n)
+
+	bestVariance := 0.0
+	for name := range loads {
+		delta := deltas[name]
+		mean := (sum + delta) / n
+
+		variance := 0.0
+		for other, load := range loads {
+			if other == name {
+				load += delta
+			}
+
+			variance += (load - mean) * (load - mean)
+		}
+
+		variance /= n
+
+		if result.Member == "" || variance < bestVariance || (variance == bestVariance && name < result.Member) {
+			result.Member = name
+			bestVariance = variance
+		}
+	}
+
+	return result
+}
+
+// instancePlacementDomainDensity returns the number of instances in each failure domain, including the
+// domains of members without instances. Member failure domains are keyed by member address.
+func instancePlacementDomainDensity(members []db.NodeInfo, memberDomains map[string]uint64, domainNames map[uint64]string, counts map[string]int) map[string]int {
+	density := map[string]int{}
+	for _, member := range members {
+		domain := domainNames[memberDomains[member.Address]]
+		density[domain] += counts[member.Name]
+	}
+
+	return density
+}
+
+// instancePlacementRendezvousHash returns the name with the highest hash weight for the given key.
+// Adding or removing a name only moves the keys that it wins or was winning.
+func instancePlacementRendezvousHash(key string, names []string) string {
+	best := ""
+	var bestWeight uint64
+	for _, name := range names {
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(key))
+		_, _ = h.Write([]byte{0})
+		_, _ = h.Write([]byte(name))
+		weight := h.Sum64()
+
+		if best == "" || weight > bestWeight || (weight == bestWeight && name < best) {
+			best = name
+			bestWeight = weight
+		}
+	}
+
+	return best
+}
+
+// InstancePlacementRunWithRetry runs the instance placement scriptlet, running it again when it requests a retry.
+// Retries are only honored a bounded number of times and within the context deadline, after which the retry request is returned as an error.
+func InstancePlacementRunWithRetry(ctx context.Context, l logger.Logger, s *state.State, req *apiScriptlet.InstancePlacement, candidateMembers []db.NodeInfo, leaderAddress string) (*InstancePlacementResult, error) {
+	for attempt := 0; ; attempt++ {
+		placement, err := InstancePlacementRun(ctx, l, s, req, candidateMembers, leaderAddress)
+
+		var retryErr ErrInstancePlacementRetry
+		if !errors.As(err, &retryErr) || attempt >= instancePlacementMaxRetries {
+			return placement, err
+		}
+
+		// Don't wait for a retry that can't run before the caller's deadline.
+		deadline, ok := ctx.Deadline()
+		if ok && time.Until(deadline) <= retryErr.After {
+			return placement, err
+		}
+
+		err = instancePlacementRetryWait(ctx, retryErr.After)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
 // InstancePlacementRun runs the instance placement scriptlet and returns the chosen cluster member target.
-func InstancePlacementRun(ctx context.Context, l logger.Logger, s *state.State, req *apiScriptlet.InstancePlacement, candidateMembers []db.NodeInfo, leaderAddress string) (*db.NodeInfo, error) {
-	ctx, cancel := context.WithCancel(ctx)
+func InstancePlacementRun(ctx context.Context, l logger.Logger, s *state.State, req *apiScriptlet.InstancePlacement, candidateMembers []db.NodeInfo, leaderAddress string) (*InstancePlacementResult, error) {
+	// Bound the run so that a slow scriptlet fails the placement rather than blocking it indefinitely.
+	timeout := s.GlobalConfig.InstancesPlacementScriptletTimeout()
+	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
-	logFunc := log.CreateLogger(l, "Instance placement scriptlet")
+	// Retry connections to remote members so that transient errors don't fail the whole run.
+	connectRetries := s.GlobalConfig.InstancesPlacementScriptletConnectRetries()
+	connectMember := func(member db.NodeInfo) (incus.InstanceServer, error) {
+		return instancePlacementConnectWithRetry(ctx, s, member, connectRetries)
+	}
+
+	// Tag all log lines with the instance being placed so that concurrent runs can be told apart.
+	l = l.AddContext(logger.Ctx{"instance": req.Name, "project": req.Project})
+
+	// With a single candidate, optionally pick it directly before running the scriptlet or querying the database.
+	if len(candidateMembers) == 1 && s.GlobalConfig.InstancesPlacementScriptletSkipSingleCandidate() && !scriptletLoad.InstancePlacementAlwaysRun() {
+		prog, _, err := scriptletLoad.InstancePlacementProgram()
+		if err != nil {
+			return nil, err
+		}
+
+		l.Debug("Instance placement scriptlet skipped with single candidate member", logger.Ctx{"member": candidateMembers[0].Name})
+
+		s.Events.SendLifecycle(req.Project, lifecycle.InstancePlaced.Event(req.Name, req.Project, map[string]any{
+			"member":    candidateMembers[0].Name,
+			"reason":    req.Reason,
+			"scriptlet": prog.Filename(),
+			"skipped":   true,
+		}))
+
+		return &InstancePlacementResult{Member: &candidateMembers[0], ConfigOverrides: map[string]string{}, Targets: []InstancePlacementTarget{{Member: &candidateMembers[0]}}}, nil
+	}
+
+	logFunc := log.CreateLogger(l, "Instance placement scriptlet")
+
+	var targetMember *db.NodeInfo
+	var rejected *ErrInstancePlacementRejected
+	var retry *ErrInstancePlacementRetry
+	configOverrides := map[string]string{}
+	var selectedPool string
+	var targets []InstancePlacementTarget
+	hiddenKeys := s.GlobalConfig.InstancesPlacementScriptletHiddenKeys()
+	rng := rand.New(rand.NewSource(instancePlacementSeed()))
+
+	// Whether the scriptlet set ignore_remote_errors, and the remote failures ignored as a result.
+	ignoreRemoteErrors := false
+	var remoteErrors []error
+
+	// memberError returns None and records the error when a remote member fails and the scriptlet ignores such failures.
+	memberError := func(memberName string, err error) (starlark.Value, error) {
+		if memberName == s.ServerName || !ignoreRemoteErrors {
+			return nil, err
+		}
+
+		remoteErrors = append(remoteErrors, fmt.Errorf("Failed getting data from member %q: %w", memberName, err))
+
+		return starlark.None, nil
+	}
+
+	// getTarget validates that the member is a candidate and that the storage pool, if any, is available on it.
+	getTarget := func(memberName string, poolName string) (*db.NodeInfo, error) {
+		var member *db.NodeInfo
+		for i := range candidateMembers {
+			if candidateMembers[i].Name == memberName {
+				member = &candidateMembers[i]
+				break
+			}
+		}
+
+		if member == nil {
+			l.Error("Instance placement scriptlet set invalid member target", logger.Ctx{"member": memberName})
+			return nil, fmt.Errorf("Invalid member name: %s", memberName)
+		}
+
+		if poolName != "" {
+			// Existing instances keep their storage pool when relocated or evacuated.
+			if req.Reason != apiScriptlet.InstancePlacementReasonNew {
+				return nil, fmt.Errorf("Storage pool selection is only supported when placing a new instance")
+			}
+
+			// Check that the storage pool is available on the selected member.
+			err := s.DB.Cluster.Transaction(ctx, func(ctx context.Context, tx *db.ClusterTx) error {
+				_, _, poolMembers, err := tx.GetStoragePool(ctx, poolName)
+				if err != nil {
+					return err
+				}
+
+				poolMember, ok := poolMembers[member.ID]
+				if !ok || poolMember.State != db.StoragePoolCreated {
+					return fmt.Errorf("Storage pool isn't available on member %q", member.Name)
+				}
+
+				return nil
+			})
+			if err != nil {
+				l.Error("Instance placement scriptlet set invalid storage pool", logger.Ctx{"member": member.Name, "pool": poolName, "err": err})
+				return nil, fmt.Errorf("Invalid storage pool %q: %w", poolName, err)
+			}
+		}
+
+		return member, nil
+	}
+
+	setTargetFunc := func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var memberName string
+		var poolName string
+
+		err := starlark.UnpackArgs(b.Name(), args, kwargs, "member_name", &memberName, "pool??", &poolName)
+		if err != nil {
+			return nil, err
+		}
+
+		member, err := getTarget(memberName, poolName)
+		if err != nil {
+			return nil, err
+		}
+
+		targetMember = member
+		selectedPool = poolName
+		targets = nil
+
+		l.Info("Instance placement scriptlet set member target", logger.Ctx{"member": targetMember.Name, "pool": poolName})
+
+		return starlark.None, nil
+	}
+
+	setTargetsFunc := func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var list *starlark.List
+
+		err := starlark.UnpackArgs(b.Name(), args, kwargs, "targets", &list)
+		if err != nil {
+			return nil, err
+		}
+
+		// Fallback targets are only tried when forwarding the creation of a new instance.
+		if req.Reason != apiScriptlet.InstancePlacementReasonNew {
+			return nil, fmt.Errorf("Multiple targets are only supported when placing a new instance")
+		}
+
+		if list.Len() == 0 {
+			return nil, fmt.Errorf("At least one target is required")
+		}
+
+		newTargets := make([]InstancePlacementTarget, 0, list.Len())
+		for i := 0; i < list.Len(); i++ {
+			dict, ok := list.Index(i).(*starlark.Dict)
+			if !ok {
+				return nil, fmt.Errorf("Invalid target %d: Expected a dict, got %s", i, list.Index(i).Type())
+			}
+
+			fields := map[string]string{}
+			for _, item := range dict.Items() {
+				key, ok := starlark.AsString(item[0])
+				if !ok || (key != "member" && key != "pool") {
+					return nil, fmt.Errorf("Invalid target %d: Unknown key %v", i, item[0])
+				}
+
+				value, ok := starlark.AsString(item[1])
+				if !ok {
+					return nil, fmt.Errorf("Invalid target %d: Invalid value for %q: %v", i, key, item[1])
+				}
+
+				fields[key] = value
+			}
+
+			if fields["member"] == "" {
+				return nil, fmt.Errorf("Invalid target %d: Missing member", i)
+			}
+
+			member, err := getTarget(fields["member"], fields["pool"])
+			if err != nil {
+				return nil, fmt.Errorf("Invalid target %d: %w", i, err)
+			}
+
+			newTargets = append(newTargets, InstancePlacementTarget{Member: member, Pool: fields["pool"]})
+		}
+
+		targets = newTargets
+		targetMember = targets[0].Member
+		selectedPool = targets[0].Pool
+
+		l.Info("Instance placement scriptlet set member targets", logger.Ctx{"member": targetMember.Name, "pool": selectedPool, "targets": len(targets)})
+
+		return starlark.None, nil
+	}
+
+	rejectPlacementFunc := func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var message string
+
+		err := starlark.UnpackArgs(b.Name(), args, kwargs, "message", &message)
+		if err != nil {
+			return nil, err
+		}
+
+		l.Info("Instance placement scriptlet rejected placement", logger.Ctx{"message": message})
+
+		// Returning an error stops the scriptlet, the rejection is reported once it has returned.
+		rejected = &ErrInstancePlacementRejected{Message: message}
+
+		return nil, rejected
+	}
+
+	requestRetryFunc := func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var afterSeconds int
+
+		err := starlark.UnpackArgs(b.Name(), args, kwargs, "after_seconds", &afterSeconds)
+		if err != nil {
+			return nil, err
+		}
+
+		if afterSeconds < 1 || afterSeconds > 10 {
+			return nil, fmt.Errorf("Retry delay must be between 1 and 10 seconds")
+		}
+
+		l.Info("Instance placement scriptlet requested a retry", logger.Ctx{"after": afterSeconds})
+
+		// Like a rejection, returning an error stops the scriptlet and the retry is reported once it has returned.
+		retry = &ErrInstancePlacementRetry{After: time.Duration(afterSeconds) * time.Second}
+
+		return nil, retry
+	}
+
+	setConfigOverrideFunc := func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var key string
+		var value string
+
+		err := starlark.UnpackArgs(b.Name(), args, kwargs, "key", &key, "value", &value)
+		if err != nil {
+			return nil, err
+		}
+
+		// Existing instances keep their configuration when relocated or evacuated.
+		if req.Reason != apiScriptlet.InstancePlacementReasonNew {
+			return nil, fmt.Errorf("Configuration overrides are only supported when placing a new instance")
+		}
+
+		if !strings.HasPrefix(key, "user.") && !slices.Contains(instancePlacementConfigOverrideKeys, key) {
+			return nil, fmt.Errorf("Configuration key %q cannot be overridden by the instance placement scriptlet", key)
+		}
+
+		configOverrides[key] = value
+
+		l.Info("Instance placement scriptlet set configuration override", logger.Ctx{"key": key, "value": value})
+
+		return starlark.None, nil
+	}
+
+	// instanceResourceUsage returns the resources required by the instance.
+	// Virtual machines without limits use the defaults from the server configuration if set, the built-in ones otherwise.
+	instanceResourceUsage := func() (int64, int64, int64, error) {
+		config := req.Config
+		devices := req.Devices
+
+		if req.Type == api.InstanceTypeVM {
+			defaultCPU, defaultMemory, defaultRootDiskSize := s.GlobalConfig.InstancesPlacementScriptletVMDefaults()
+
+			config = maps.Clone(config)
+			if config == nil {
+				config = map[string]string{}
+			}
+
+			if config["limits.cpu"] == "" && defaultCPU != "" {
+				config["limits.cpu"] = defaultCPU
+			}
+
+			if config["limits.memory"] == "" && defaultMemory != "" {
+				config["limits.memory"] = defaultMemory
+			}
+
+			rootDevName, rootDev, err := localInstance.GetRootDiskDevice(devices)
+			if err == nil && rootDev["size"] == "" && defaultRootDiskSize != "" {
+				devices = maps.Clone(devices)
+				devices[rootDevName] = maps.Clone(rootDev)
+				devices[rootDevName]["size"] = defaultRootDiskSize
+			}
+		}
+
+		return internalInstance.ResourceUsage(config, devices, req.Type)
+	}
+
+	// getMemberResources returns the resources of the given cluster member, or nil if it's not a candidate member.
+	// Resources are cached for the duration of the run as the scriptlet may query the same member several times.
+	memberResources := map[string]*api.Resources{}
+
+	getCandidateMember := func(memberName string) *db.NodeInfo {
+		for i := range candidateMembers {
+			if candidateMembers[i].Name == memberName {
+				return &candidateMembers[i]
+			}
+		}
+
+		return nil
+	}
+
+	getMemberResources := func(memberName string) (*api.Resources, error) {
+		res, ok := memberResources[memberName]
+		if ok {
+			return res, nil
+		}
+
+		if memberName == s.ServerName {
+			// Get the local resource usage.
+			var err error
+			res, err = resources.GetResources()
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			// Get remote member resource usage.
+			targetMember := getCandidateMember(memberName)
+			if targetMember == nil {
+				return nil, nil
+			}
+
+			client, err := connectMember(*targetMember)
+			if err != nil {
+				return nil, err
+			}
+
+			res, err = client.GetServerResources()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		memberResources[memberName] = res
+
+		return res, nil
+	}
+
+	// getMemberState returns the state of the given cluster member, or nil if it's not a candidate member.
+	// Like resources, the state is cached for the duration of the run.
+	memberStates := map[string]*api.ClusterMemberState{}
+
+	getMemberState := func(memberName string) (*api.ClusterMemberState, error) {
+		memberState, ok := memberStates[memberName]
+		if ok {
+			return memberState, nil
+		}
+
+		if memberName == s.ServerName {
+			// Get the local resource usage.
+			var err error
+			memberState, err = cluster.MemberState(ctx, s, memberName)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			// Get remote member resource usage.
+			targetMember := getCandidateMember(memberName)
+			if targetMember == nil {
+				return nil, nil
+			}
+
+			client, err := connectMember(*targetMember)
+			if err != nil {
+				return nil, err
+			}
+
+			memberState, _, err = client.GetClusterMemberState(memberName)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		memberStates[memberName] = memberState
+
+		return memberState, nil
+	}
+
+	getMemberPoolResources := func(memberName string, poolName string) (*api.ResourcesStoragePool, error) {
+		// Get the local storage pool usage.
+		if memberName == s.ServerName {
+			pool, err := storagePools.LoadByName(s, poolName)
+			if err != nil {
+				return nil, err
+			}
+
+			return pool.GetResources()
+		}
+
+		// Get remote member storage pool usage.
+		targetMember := getCandidateMember(memberName)
+		if targetMember == nil {
+			return nil, nil
+		}
+
+		client, err := connectMember(*targetMember)
+		if err != nil {
+			return nil, err
+		}
+
+		return client.GetStoragePoolResources(poolName)
+	}
+
+	poolFreeSpaceFunc := func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var memberName string
+		var poolName string
+
+		err := starlark.UnpackArgs(b.Name(), args, kwargs, "member_name", &memberName, "pool", &poolName)
+		if err != nil {
+			return nil, err
+		}
+
+		if memberName != s.ServerName && getCandidateMember(memberName) == nil {
+			return starlark.String("Invalid member name"), nil
+		}
+
+		poolRes, err := getMemberPoolResources(memberName, poolName)
+		if err != nil {
+			return memberError(memberName, fmt.Errorf("Failed getting storage pool %q resources on member %q: %w", poolName, memberName, err))
+		}
+
+		if poolRes.Space.Used > poolRes.Space.Total {
+			return starlark.MakeUint64(0), nil
+		}
+
+		return starlark.MakeUint64(poolRes.Space.Total - poolRes.Space.Used), nil
+	}
+
+	getStoragePoolDriverFunc := func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var memberName string
+		var poolName string
+
+		err := starlark.UnpackArgs(b.Name(), args, kwargs, "member_name", &memberName, "pool", &poolName)
+		if err != nil {
+			return nil, err
+		}
+
+		var driverName string
+
+		if memberName == s.ServerName {
+			// Get the local storage pool.
+			err = s.DB.Cluster.Transaction(ctx, func(ctx context.Context, tx *db.ClusterTx) error {
+				_, pool, _, err := tx.GetStoragePool(ctx, poolName)
+				if err != nil {
+					return err
+				}
+
+				driverName = pool.Driver
+
+				return nil
+			})
+			if err != nil {
+				return nil, fmt.Errorf("Failed getting storage pool %q: %w", poolName, err)
+			}
+		} else {
+			// Get the remote member storage pool.
+			targetMember := getCandidateMember(memberName)
+			if targetMember == nil {
+				return starlark.String("Invalid member name"), nil
+			}
+
+			client, err := connectMember(*targetMember)
+			if err != nil {
+				return memberError(memberName, err)
+			}
+
+			pool, _, err := client.GetStoragePool(poolName)
+			if err != nil {
+				return memberError(memberName, fmt.Errorf("Failed getting storage pool %q on member %q: %w", poolName, memberName, err))
+			}
+
+			driverName = pool.Driver
+		}
+
+		// The storage pool driver is the same on all members, so its capabilities can be read locally.
+		pool, err := storagePools.LoadByName(s, poolName)
+		if err != nil {
+			return nil, fmt.Errorf("Failed loading storage pool %q: %w", poolName, err)
+		}
+
+		info := pool.Driver().Info()
+
+		rv, err := marshal.StarlarkMarshal(apiScriptlet.StoragePoolDriver{
+			Name:            driverName,
+			Remote:          info.Remote,
+			OptimizedImages: info.OptimizedImages,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Marshalling storage pool driver failed: %w", err)
+		}
+
+		return rv, nil
+	}
+
+	getMemberMetricsFunc := func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var memberName string
+		since := 600
+
+		err := starlark.UnpackArgs(b.Name(), args, kwargs, "member_name", &memberName, "since??", &since)
+		if err != nil {
+			return nil, err
+		}
+
+		// Bound the window to the retained history.
+		maxSince := int(metrics.MemberHistorySize * metrics.MemberHistoryInterval / time.Second)
+		if since <= 0 || since > maxSince {
+			return nil, fmt.Errorf("Invalid since value %d: Must be between 1 and %d seconds", since, maxSince)
+		}
+
+		sinceTime := instancePlacementNow().Add(-time.Duration(since) * time.Second)
+
+		var samples []apiScriptlet.MemberMetricsSample
+
+		if memberName == s.ServerName {
+			// Get the local samples.
+			samples = metrics.LocalMemberHistory.Since(sinceTime)
+		} else {
+			// Get the remote member samples.
+			targetMember := getCandidateMember(memberName)
+			if targetMember == nil {
+				return starlark.String("Invalid member name"), nil
+			}
+
+			client, err := connectMember(*targetMember)
+			if err != nil {
+				return memberError(memberName, err)
+			}
+
+			resp, _, err := client.RawQuery("GET", fmt.Sprintf("/internal/metrics/history?since=%d", sinceTime.Unix()), nil, "")
+			if err != nil {
+				return memberError(memberName, fmt.Errorf("Failed getting metrics of member %q: %w", memberName, err))
+			}
+
+			err = json.Unmarshal(resp.Metadata, &samples)
+			if err != nil {
+				return memberError(memberName, fmt.Errorf("Failed parsing metrics of member %q: %w", memberName, err))
+			}
+		}
+
+		rv, err := marshal.StarlarkMarshal(samples)
+		if err != nil {
+			return nil, fmt.Errorf("Marshalling cluster member metrics for %q failed: %w", memberName, err)
+		}
+
+		return rv, nil
+	}
+
+	getMemberAutostartFailuresFunc := func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var memberName string
+		since := 3600
+
+		err := starlark.UnpackArgs(b.Name(), args, kwargs, "member_name", &memberName, "since??", &since)
+		if err != nil {
+			return nil, err
+		}
+
+		// Bound the window to a day as older failures say little about the current state of the member.
+		maxSince := int(24 * time.Hour / time.Second)
+		if since <= 0 || since > maxSince {
+			return nil, fmt.Errorf("Invalid since value %d: Must be between 1 and %d seconds", since, maxSince)
+		}
+
+		sinceTime := instancePlacementNow().Add(-time.Duration(since) * time.Second)
+		failures := []apiScriptlet.MemberAutostartFailure{}
+
+		// Failed starts are only persisted as autostart warnings, there's no history of other start attempts.
+		err = s.DB.Cluster.Transaction(ctx, func(ctx context.Context, tx *db.ClusterTx) error {
+			// Check the member exists so that a typo isn't reported as a member without failures.
+			_, err := tx.GetNodeByName(ctx, memberName)
+			if err != nil {
+				return err
+			}
+
+			typeCode := warningtype.InstanceAutostartFailure
+			warnings, err := dbCluster.GetWarnings(ctx, tx.Tx(), dbCluster.WarningFilter{Node: &memberName, TypeCode: &typeCode})
+			if err != nil {
+				return err
+			}
+
+			for _, warning := range warnings {
+				if warning.LastSeenDate.Before(sinceTime) || warning.EntityTypeCode != dbCluster.TypeInstance {
+					continue
+				}
+
+				instanceName := ""
+				instances, err := dbCluster.GetInstances(ctx, tx.Tx(), dbCluster.InstanceFilter{ID: &warning.EntityID})
+				if err != nil {
+					return err
+				}
+
+				if len(instances) > 0 {
+					instanceName = instances[0].Name
+				}
+
+				failures = append(failures, apiScriptlet.MemberAutostartFailure{
+					Project:    warning.Project,
+					Instance:   instanceName,
+					Message:    warning.LastMessage,
+					Count:      warning.Count,
+					LastSeenAt: warning.LastSeenDate.Unix(),
+				})
+			}
+
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Failed getting recent failures of member %q: %w", memberName, err)
+		}
+
+		// Most recent failures first.
+		sort.SliceStable(failures, func(i, j int) bool { return failures[i].LastSeenAt > failures[j].LastSeenAt })
+
+		rv, err := marshal.StarlarkMarshal(failures)
+		if err != nil {
+			return nil, fmt.Errorf("Marshalling recent failures of member %q failed: %w", memberName, err)
+		}
+
+		return rv, nil
+	}
+
+	memberSupportsMemoryHotplugFunc := func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var memberName string
+
+		err := starlark.UnpackArgs(b.Name(), args, kwargs, "member_name", &memberName)
+		if err != nil {
+			return nil, err
+		}
+
+		res, err := getMemberResources(memberName)
+		if err != nil {
+			return memberError(memberName, err)
+		}
+
+		if res == nil {
+			return starlark.String("Invalid member name"), nil
+		}
+
+		return starlark.Bool(instancePlacementMemberSupportsMemoryHotplug(res)), nil
+	}
+
+	canLiveMigrateFunc := func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var sourceMember string
+		var targetMember string
+
+		err := starlark.UnpackArgs(b.Name(), args, kwargs, "source_member", &sourceMember, "target_member", &targetMember)
+		if err != nil {
+			return nil, err
+		}
+
+		resources := make([]*api.Resources, 0, 2)
+		for _, memberName := range []string{sourceMember, targetMember} {
+			res, err := getMemberResources(memberName)
+			if err != nil {
+				return memberError(memberName, err)
+			}
+
+			// The source member usually isn't a candidate, look it up among all cluster members.
+			if res == nil && memberName == sourceMember {
+				var member db.NodeInfo
+
+				err = s.DB.Cluster.Transaction(ctx, func(ctx context.Context, tx *db.ClusterTx) error {
+					member, err = tx.GetNodeByName(ctx, memberName)
+					return err
+				})
+				if err != nil && !api.StatusErrorCheck(err, http.StatusNotFound) {
+					return nil, fmt.Errorf("Failed getting cluster member %q: %w", memberName, err)
+				}
+
+				if err == nil {
+					client, err := connectMember(member)
+					if err != nil {
+						return memberError(memberName, err)
+					}
+
+					res, err = client.GetServerResources()
+					if err != nil {
+						return memberError(memberName, err)
+					}
+
+					memberResources[memberName] = res
+				}
+			}
+
+			if res == nil {
+				return starlark.String("Invalid member name"), nil
+			}
+
+			resources = append(resources, res)
+		}
+
+		ok, reason := instancePlacementCanLiveMigrate(resources[0], resources[1])
+
+		return starlark.Tuple{starlark.Bool(ok), starlark.String(reason)}, nil
+	}
+
+	memberFitsFunc := func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var memberName string
+
+		err := starlark.UnpackArgs(b.Name(), args, kwargs, "member_name", &memberName)
+		if err != nil {
+			return nil, err
+		}
+
+		usageCPU, usageMemory, usageDisk, err := instanceResourceUsage()
+		if err != nil {
+			return nil, fmt.Errorf("Failed to calculate instance resource usage: %w", err)
+		}
+
+		usage := apiScriptlet.InstanceResources{
+			CPUCores:     uint64(usageCPU),
+			MemorySize:   uint64(usageMemory),
+			RootDiskSize: uint64(usageDisk),
+		}
+
+		res, err := getMemberResources(memberName)
+		if err != nil {
+			return memberError(memberName, err)
+		}
+
+		if res == nil {
+			return starlark.String("Invalid member name"), nil
+		}
+
+		// Only check the disk space if the instance has a root disk with a known pool.
+		var poolRes *api.ResourcesStoragePool
+		_, rootDev, err := localInstance.GetRootDiskDevice(req.Devices)
+		if err == nil && rootDev["pool"] != "" {
+			poolRes, err = getMemberPoolResources(memberName, rootDev["pool"])
+			if err != nil {
+				return memberError(memberName, fmt.Errorf("Failed getting storage pool %q resources on member %q: %w", rootDev["pool"], memberName, err))
+			}
+		}
+
+		limit := instancePlacementFits(usage, res, poolRes)
+
+		return starlark.Tuple{starlark.Bool(limit == ""), starlark.String(limit)}, nil
+	}
+
+	chooseWeightedFunc := func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var weightsDict *starlark.Dict
+
+		err := starlark.UnpackArgs(b.Name(), args, kwargs, "weights", &weightsDict)
+		if err != nil {
+			return nil, err
+		}
+
+		weights := make(map[string]float64, weightsDict.Len())
+		for _, item := range weightsDict.Items() {
+			memberName, ok := starlark.AsString(item[0])
+			if !ok {
+				return nil, fmt.Errorf("Invalid member name: %v", item[0])
+			}
+
+			if !slices.ContainsFunc(candidateMembers, func(member db.NodeInfo) bool { return member.Name == memberName }) {
+				return nil, fmt.Errorf("Invalid member name: %s", memberName)
+			}
+
+			weight, ok := starlark.AsFloat(item[1])
+			if !ok || weight < 0 {
+				return nil, fmt.Errorf("Invalid weight for member %q: %v", memberName, item[1])
+			}
+
+			weights[memberName] = weight
+		}
+
+		memberName, err := instancePlacementChooseWeighted(rng, weights)
+		if err != nil {
+			return nil, err
+		}
+
+		return starlark.String(memberName), nil
+	}
+
+	getRandomFunc := func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var seedv starlark.Value
+
+		err := starlark.UnpackArgs(b.Name(), args, kwargs, "seed??", &seedv)
+		if err != nil {
+			return nil, err
+		}
+
+		// Re-seed the generator used by the whole run, making the following choices reproducible.
+		if seedv != nil && seedv != starlark.None {
+			seed, err := starlark.AsInt32(seedv)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid seed: %w", err)
+			}
+
+			rng.Seed(int64(seed))
+		}
+
+		return starlark.Float(rng.Float64()), nil
+	}
+
+	rendezvousHashFunc := func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var key string
+		var memberNamesList *starlark.List
+
+		err := starlark.UnpackArgs(b.Name(), args, kwargs, "key", &key, "member_names??", &memberNamesList)
+		if err != nil {
+			return nil, err
+		}
+
+		var memberNames []string
+		if memberNamesList != nil {
+			for i := 0; i < memberNamesList.Len(); i++ {
+				memberName, ok := starlark.AsString(memberNamesList.Index(i))
+				if !ok {
+					return nil, fmt.Errorf("Invalid member name: %v", memberNamesList.Index(i))
+				}
+
+				memberNames = append(memberNames, memberName)
+			}
+		} else {
+			for _, member := range candidateMembers {
+				memberNames = append(memberNames, member.Name)
+			}
+		}
+
+		if len(memberNames) == 0 {
+			return nil, fmt.Errorf("No member names to hash over")
+		}
+
+		return starlark.String(instancePlacementRendezvousHash(key, memberNames)), nil
+	}
+
+	getClusterMemberResourcesFunc := func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var memberName string
+
+		err := starlark.UnpackArgs(b.Name(), args, kwargs, "member_name", &memberName)
+		if err != nil {
+			return nil, err
+		}
+
+		res, err := getMemberResources(memberName)
+		if err != nil {
+			return memberError(memberName, err)
+		}
+
+		if res == nil {
+			return starlark.String("Invalid member name"), nil
+		}
+
+		rv, err := marshal.StarlarkMarshal(res)
+		if err != nil {
+			return nil, fmt.Errorf("Marshalling cluster member resources for %q failed: %w", memberName, err)
+		}
+
+		return rv, nil
+	}
+
+	getMemberGPUsFunc := func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var memberName string
+
+		err := starlark.UnpackArgs(b.Name(), args, kwargs, "member_name", &memberName)
+		if err != nil {
+			return nil, err
+		}
+
+		res, err := getMemberResources(memberName)
+		if err != nil {
+			return memberError(memberName, err)
+		}
+
+		if res == nil {
+			return starlark.String("Invalid member name"), nil
+		}
+
+		rv, err := marshal.StarlarkMarshal(instancePlacementMemberGPUs(res))
+		if err != nil {
+			return nil, fmt.Errorf("Marshalling cluster member GPUs for %q failed: %w", memberName, err)
+		}
+
+		return rv, nil
+	}
+
+	getMemberNetworkPortsFunc := func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var memberName string
+
+		err := starlark.UnpackArgs(b.Name(), args, kwargs, "member_name", &memberName)
+		if err != nil {
+			return nil, err
+		}
+
+		res, err := getMemberResources(memberName)
+		if err != nil {
+			return memberError(memberName, err)
+		}
+
+		if res == nil {
+			return starlark.String("Invalid member name"), nil
+		}
+
+		rv, err := marshal.StarlarkMarshal(instancePlacementMemberNetworkPorts(res))
+		if err != nil {
+			return nil, fmt.Errorf("Marshalling cluster member network ports for %q failed: %w", memberName, err)
+		}
+
+		return rv, nil
+	}
+
+	getMemberHugepagesFunc := func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var memberName string
+
+		err := starlark.UnpackArgs(b.Name(), args, kwargs, "member_name", &memberName)
+		if err != nil {
+			return nil, err
+		}
+
+		res, err := getMemberResources(memberName)
+		if err != nil {
+			return memberError(memberName, err)
+		}
+
+		if res == nil {
+			return starlark.String("Invalid member name"), nil
+		}
+
+		rv, err := marshal.StarlarkMarshal(instancePlacementMemberHugepages(res))
+		if err != nil {
+			return nil, fmt.Errorf("Marshalling cluster member huge pages for %q failed: %w", memberName, err)
+		}
+
+		return rv, nil
+	}
+
+	getClusterResourcesFunc := func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		err := starlark.UnpackArgs(b.Name(), args, kwargs)
+		if err != nil {
+			return nil, err
+		}
+
+		members := make([]*api.Resources, 0, len(candidateMembers))
+		for _, member := range candidateMembers {
+			res, err := getMemberResources(member.Name)
+			if err != nil {
+				// Members that can't be reached are left out of the totals when ignoring remote errors.
+				_, err = memberError(member.Name, err)
+				if err != nil {
+					return nil, err
+				}
+
+				continue
+			}
+
+			members = append(members, res)
+		}
+
+		rv, err := marshal.StarlarkMarshal(instancePlacementClusterResources(members))
+		if err != nil {
+			return nil, fmt.Errorf("Marshalling cluster resources failed: %w", err)
+		}
+
+		return rv, nil
+	}
+
+	getClusterLimitsFunc := func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		err := starlark.UnpackArgs(b.Name(), args, kwargs)
+		if err != nil {
+			return nil, err
+		}
+
+		limits := apiScriptlet.ClusterLimits{
+			OfflineThreshold:   int64(s.GlobalConfig.OfflineThreshold() / time.Second),
+			HealingThreshold:   int64(s.GlobalConfig.ClusterHealingThreshold() / time.Second),
+			RebalanceThreshold: s.GlobalConfig.ClusterRebalanceThreshold(),
+			RebalanceBatch:     s.GlobalConfig.ClusterRebalanceBatch(),
+		}
+
+		limits.VMDefaultCPU, limits.VMDefaultMemory, limits.VMDefaultRootDiskSize = s.GlobalConfig.InstancesPlacementScriptletVMDefaults()
+
+		rv, err := marshal.StarlarkMarshal(limits)
+		if err != nil {
+			return nil, fmt.Errorf("Marshalling cluster limits failed: %w", err)
+		}
+
+		return rv, nil
+	}
+
+	getClusterMemberStateFunc := func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var memberName string
+
+		err := starlark.UnpackArgs(b.Name(), args, kwargs, "member_name", &memberName)
+		if err != nil {
+			return nil, err
+		}
+
+		if memberName != s.ServerName && getCandidateMember(memberName) == nil {
+			return starlark.String("Invalid member name"), nil
+		}
+
+		memberState, err := getMemberState(memberName)
+		if err != nil {
+			if memberName == s.ServerName {
+				return nil, err
+			}
+
+			return memberError(memberName, err)
+		}
+
+		rv, err := marshal.StarlarkMarshal(memberState)
+		if err != nil {
+			return nil, fmt.Errorf("Marshalling cluster member state for %q failed: %w", memberName, err)
+		}
+
+		return rv, nil
+	}
+
+	getClusterMemberRolesFunc := func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var memberName string
+
+		err := starlark.UnpackArgs(b.Name(), args, kwargs, "member_name", &memberName)
+		if err != nil {
+			return nil, err
+		}
+
+		var member db.NodeInfo
+
+		err = s.DB.Cluster.Transaction(ctx, func(ctx context.Context, tx *db.ClusterTx) error {
+			member, err = tx.GetNodeByName(ctx, memberName)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Failed getting cluster member %q: %w", memberName, err)
+		}
+
+		var raftNodes []db.RaftNode
+		err = s.DB.Node.Transaction(ctx, func(ctx context.Context, tx *db.NodeTx) error {
+			raftNodes, err = tx.GetRaftNodes(ctx)
+			if err != nil {
+				return fmt.Errorf("Failed loading RAFT nodes: %w", err)
+			}
+
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		rv, err := marshal.StarlarkMarshal(instancePlacementMemberRoles(member, raftNodes, leaderAddress))
+		if err != nil {
+			return nil, fmt.Errorf("Marshalling cluster member roles for %q failed: %w", memberName, err)
+		}
+
+		return rv, nil
+	}
+
+	memberFailureDomainFunc := func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var memberName string
+
+		err := starlark.UnpackArgs(b.Name(), args, kwargs, "member_name", &memberName)
+		if err != nil {
+			return nil, err
+		}
+
+		var domain string
+
+		err = s.DB.Cluster.Transaction(ctx, func(ctx context.Context, tx *db.ClusterTx) error {
+			member, err := tx.GetNodeByName(ctx, memberName)
+			if err != nil {
+				return fmt.Errorf("Failed getting cluster member %q: %w", memberName, err)
+			}
+
+			domain, err = tx.GetNodeFailureDomain(ctx, member.ID)
+			if err != nil {
+				return fmt.Errorf("Failed getting failure domain of cluster member %q: %w", memberName, err)
+			}
+
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		return starlark.String(domain), nil
+	}
+
+	domainDensityFunc := func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		err := starlark.UnpackArgs(b.Name(), args, kwargs)
+		if err != nil {
+			return nil, err
+		}
+
+		var density map[string]int
+
+		err = s.DB.Cluster.Transaction(ctx, func(ctx context.Context, tx *db.ClusterTx) error {
+			members, err := tx.GetNodes(ctx)
+			if err != nil {
+				return fmt.Errorf("Failed getting cluster members: %w", err)
+			}
+
+			domainNames, err := tx.GetFailureDomainsNames(ctx)
+			if err != nil {
+				return fmt.Errorf("Failed loading failure domains names: %w", err)
+			}
+
+			memberDomains, err := tx.GetNodesFailureDomains(ctx)
+			if err != nil {
+				return fmt.Errorf("Failed loading member failure domains: %w", err)
+			}
+
+			counts, err := tx.GetInstancesCountByMember(ctx, "", instancetype.Any, "", "")
+			if err != nil {
+				return err
+			}
+
+			density = instancePlacementDomainDensity(members, memberDomains, domainNames, counts)
+
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		rv, err := marshal.StarlarkMarshal(density)
+		if err != nil {
+			return nil, fmt.Errorf("Marshalling failure domain density failed: %w", err)
+		}
+
+		return rv, nil
+	}
+
+	getOVNChassisFunc := func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		err := starlark.UnpackArgs(b.Name(), args, kwargs)
+		if err != nil {
+			return nil, err
+		}
+
+		var members []db.NodeInfo
+
+		err = s.DB.Cluster.Transaction(ctx, func(ctx context.Context, tx *db.ClusterTx) error {
+			members, err = tx.GetNodes(ctx)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Failed getting cluster members: %w", err)
+		}
+
+		rv, err := marshal.StarlarkMarshal(instancePlacementOVNChassis(members))
+		if err != nil {
+			return nil, fmt.Errorf("Marshalling OVN chassis failed: %w", err)
+		}
+
+		return rv, nil
+	}
+
+	getMemberMaintenanceFunc := func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var memberName string
+
+		err := starlark.UnpackArgs(b.Name(), args, kwargs, "member_name", &memberName)
+		if err != nil {
+			return nil, err
+		}
+
+		var member db.NodeInfo
+
+		err = s.DB.Cluster.Transaction(ctx, func(ctx context.Context, tx *db.ClusterTx) error {
+			member, err = tx.GetNodeByName(ctx, memberName)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Failed getting cluster member %q: %w", memberName, err)
+		}
+
+		return starlark.String(instancePlacementMemberMaintenance(member)), nil
+	}
+
+	memberSupportsArchFunc := func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var memberName string
+		var arch string
+
+		err := starlark.UnpackArgs(b.Name(), args, kwargs, "member_name", &memberName, "arch??", &arch)
+		if err != nil {
+			return nil, err
+		}
+
+		member := getCandidateMember(memberName)
+		if member == nil {
+			return starlark.String("Invalid member name"), nil
+		}
+
+		if arch == "" {
+			arch = req.Architecture
+		}
+
+		// Without an architecture requirement, any member will do.
+		if arch == "" {
+			return starlark.True, nil
+		}
+
+		supported, err := instancePlacementMemberSupportsArch(*member, arch)
+		if err != nil {
+			return nil, fmt.Errorf("Failed checking architecture %q on cluster member %q: %w", arch, memberName, err)
+		}
+
+		return starlark.Bool(supported), nil
+	}
+
+	getInstanceDeviceCountsFunc := func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		err := starlark.UnpackArgs(b.Name(), args, kwargs)
+		if err != nil {
+			return nil, err
+		}
+
+		rv, err := marshal.StarlarkMarshal(instancePlacementDeviceCounts(req.Devices))
+		if err != nil {
+			return nil, fmt.Errorf("Marshalling instance device counts failed: %w", err)
+		}
+
+		return rv, nil
+	}
+
+	getInstanceNetworkRequirementsFunc := func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		err := starlark.UnpackArgs(b.Name(), args, kwargs)
+		if err != nil {
+			return nil, err
+		}
+
+		reqs, err := instancePlacementNetworkRequirements(req.Devices)
+		if err != nil {
+			return nil, err
+		}
+
+		rv, err := marshal.StarlarkMarshal(reqs)
+		if err != nil {
+			return nil, fmt.Errorf("Marshalling instance network requirements failed: %w", err)
+		}
+
+		return rv, nil
+	}
+
+	getInstanceConfigSourcesFunc := func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		err := starlark.UnpackArgs(b.Name(), args, kwargs)
+		if err != nil {
+			return nil, err
+		}
+
+		config := instancePlacementFilterConfig(req.Config, hiddenKeys)
+		sources := make(map[string]apiScriptlet.InstanceConfigSource, len(config))
+		for key := range config {
+			// Keys of unknown origin are assumed to be set on the instance.
+			profileName := req.ConfigSources[key]
+			if profileName == "" {
+				sources[key] = apiScriptlet.InstanceConfigSource{Source: "instance"}
+			} else {
+				sources[key] = apiScriptlet.InstanceConfigSource{Source: "profile", Profile: profileName}
+			}
+		}
+
+		rv, err := marshal.StarlarkMarshal(sources)
+		if err != nil {
+			return nil, fmt.Errorf("Marshalling instance config sources failed: %w", err)
+		}
+
+		return rv, nil
+	}
+
+	getInstanceResourcesFunc := func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var err error
+		var res apiScriptlet.InstanceResources
+
+		usageCPU, usageMemory, usageDisk, err := instanceResourceUsage()
+		if err != nil {
+			return nil, fmt.Errorf("Failed to calculate instance resource usage: %w", err)
+		}
+
+		res.CPUCores = uint64(usageCPU)
+		res.MemorySize = uint64(usageMemory)
+		res.RootDiskSize = uint64(usageDisk)
+
+		_, rootDev, err := localInstance.GetRootDiskDevice(req.Devices)
+		if err == nil {
+			res.RootDiskPool = rootDev["pool"]
+		}
+
+		rv, err := marshal.StarlarkMarshal(res)
+		if err != nil {
+			return nil, fmt.Errorf("Marshalling instance resources failed: %w", err)
+		}
+
+		return rv, nil
+	}
+
+	getInstanceSnapshotsFunc := func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var name string
+		var projectName string
+
+		err := starlark.UnpackArgs(b.Name(), args, kwargs, "name", &name, "project??", &projectName)
+		if err != nil {
+			return nil, err
+		}
+
+		if projectName == "" {
+			projectName = req.Project
+		}
+
+		snapshots := []apiScriptlet.InstanceSnapshot{}
+
+		err = s.DB.Cluster.Transaction(ctx, func(ctx context.Context, tx *db.ClusterTx) error {
+			dbSnapshots, err := dbCluster.GetInstanceSnapshots(ctx, tx.Tx(), dbCluster.InstanceSnapshotFilter{Project: &projectName, Instance: &name})
+			if err != nil {
+				return err
+			}
+
+			// Fail on missing instances rather than returning an empty list.
+			if len(dbSnapshots) == 0 {
+				_, err = dbCluster.GetInstance(ctx, tx.Tx(), projectName, name)
+				if err != nil {
+					return err
+				}
+			}
 
-	var targetMember *db.NodeInfo
+			sort.SliceStable(dbSnapshots, func(i, j int) bool {
+				return dbSnapshots[i].CreationDate.Before(dbSnapshots[j].CreationDate)
+			})
 
-	setTargetFunc := func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
-		var memberName string
+			for _, dbSnapshot := range dbSnapshots {
+				snapshot := apiScriptlet.InstanceSnapshot{
+					Name:      dbSnapshot.Name,
+					CreatedAt: dbSnapshot.CreationDate.Unix(),
+					Stateful:  dbSnapshot.Stateful,
+				}
 
-		err := starlark.UnpackArgs(b.Name(), args, kwargs, "member_name", &memberName)
+				if dbSnapshot.ExpiryDate.Valid && !dbSnapshot.ExpiryDate.Time.IsZero() {
+					snapshot.ExpiresAt = dbSnapshot.ExpiryDate.Time.Unix()
+				}
+
+				snapshots = append(snapshots, snapshot)
+			}
+
+			return nil
+		})
 		if err != nil {
 			return nil, err
 		}
 
-		for i := range candidateMembers {
-			if candidateMembers[i].Name == memberName {
-				targetMember = &candidateMembers[i]
-				break
-			}
+		rv, err := marshal.StarlarkMarshal(snapshots)
+		if err != nil {
+			return nil, fmt.Errorf("Marshalling instance snapshots failed: %w", err)
 		}
 
-		if targetMember == nil {
-			l.Error("Instance placement scriptlet set invalid member target", logger.Ctx{"member": memberName})
-			return starlark.String("Invalid member name"), fmt.Errorf("Invalid member name: %s", memberName)
+		return rv, nil
+	}
+
+	getInstanceVolumesFunc := func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var name string
+		var projectName string
+
+		err := starlark.UnpackArgs(b.Name(), args, kwargs, "name", &name, "project??", &projectName)
+		if err != nil {
+			return nil, err
+		}
+
+		if projectName == "" {
+			projectName = req.Project
 		}
 
-		l.Info("Instance placement scriptlet set member target", logger.Ctx{"member": targetMember.Name})
+		volumes := []apiScriptlet.InstanceVolume{}
 
-		return starlark.None, nil
+		err = s.DB.Cluster.Transaction(ctx, func(ctx context.Context, tx *db.ClusterTx) error {
+			dbInstance, err := dbCluster.GetInstance(ctx, tx.Tx(), projectName, name)
+			if err != nil {
+				return err
+			}
+
+			instance, err := dbInstance.ToAPI(ctx, tx.Tx(), nil, nil, nil)
+			if err != nil {
+				return err
+			}
+
+			dbProject, err := dbCluster.GetProject(ctx, tx.Tx(), projectName)
+			if err != nil {
+				return err
+			}
+
+			apiProject, err := dbProject.ToAPI(ctx, tx.Tx())
+			if err != nil {
+				return err
+			}
+
+			volumeProjectName := project.StorageVolumeProjectFromRecord(apiProject, db.StoragePoolVolumeTypeCustom)
+
+			deviceNames := make([]string, 0, len(instance.ExpandedDevices))
+			for deviceName := range instance.ExpandedDevices {
+				deviceNames = append(deviceNames, deviceName)
+			}
+
+			sort.Strings(deviceNames)
+
+			for _, deviceName := range deviceNames {
+				device := instance.ExpandedDevices[deviceName]
+
+				// Only consider custom volumes, skipping the root disk and host paths.
+				if device["type"] != "disk" || device["pool"] == "" || device["source"] == "" || device["path"] == "/" {
+					continue
+				}
+
+				poolID, err := tx.GetStoragePoolID(ctx, device["pool"])
+				if err != nil {
+					return fmt.Errorf("Failed getting storage pool %q: %w", device["pool"], err)
+				}
+
+				volumeName := device["source"]
+				volumeType := db.StoragePoolVolumeTypeCustom
+				dbVolumes, err := tx.GetStoragePoolVolumes(ctx, poolID, false, db.StorageVolumeFilter{Project: &volumeProjectName, Type: &volumeType, Name: &volumeName})
+				if err != nil {
+					return fmt.Errorf("Failed getting storage volume %q: %w", volumeName, err)
+				}
+
+				// Volumes on local pools may exist on several members, use the one next to the instance.
+				for _, dbVolume := range dbVolumes {
+					if dbVolume.Location != "" && dbVolume.Location != instance.Location {
+						continue
+					}
+
+					volumes = append(volumes, apiScriptlet.InstanceVolume{
+						Device:   deviceName,
+						Name:     dbVolume.Name,
+						Pool:     device["pool"],
+						Project:  dbVolume.Project,
+						Location: dbVolume.Location,
+					})
+
+					break
+				}
+			}
+
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		rv, err := marshal.StarlarkMarshal(volumes)
+		if err != nil {
+			return nil, fmt.Errorf("Marshalling instance volumes failed: %w", err)
+		}
+
+		return rv, nil
 	}
 
-	getClusterMemberResourcesFunc := func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
-		var memberName string
+	getInstancesFunc := func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var project string
+		var location string
+		var includePending bool
 
-		err := starlark.UnpackArgs(b.Name(), args, kwargs, "member_name", &memberName)
+		err := starlark.UnpackArgs(b.Name(), args, kwargs, "project??", &project, "location??", &location, "pending??", &includePending)
 		if err != nil {
 			return nil, err
 		}
 
-		var res *api.Resources
+		instanceList := []api.Instance{}
 
-		// Get the local resource usage.
-		if memberName == s.ServerName {
-			res, err = resources.GetResources()
+		err = s.DB.Cluster.Transaction(ctx, func(ctx context.Context, tx *db.ClusterTx) error {
+			var objects []dbCluster.Instance
+
+			if project != "" || location != "" {
+				// Prepare a filter.
+				filter := dbCluster.InstanceFilter{}
+
+				if project != "" {
+					filter.Project = &project
+				}
+
+				if location != "" {
+					filter.Node = &location
+				}
+
+				// Get instances based on Project and/or Location filters.
+				objects, err = dbCluster.GetInstances(ctx, tx.Tx(), filter)
+				if err != nil {
+					return err
+				}
+			} else {
+				// Get all instances.
+				objects, err = dbCluster.GetInstances(ctx, tx.Tx())
+				if err != nil {
+					return err
+				}
+			}
+
+			objectDevices, err := dbCluster.GetDevices(ctx, tx.Tx(), "instance")
 			if err != nil {
-				return nil, err
+				return err
 			}
-		} else {
-			// Get remote member resource usage.
-			var targetMember *db.NodeInfo
-			for i := range candidateMembers {
-				if candidateMembers[i].Name == memberName {
-					targetMember = &candidateMembers[i]
-					break
+
+			// Convert the []Instances into []api.Instances.
+			for _, obj := range objects {
+				instance, err := obj.ToAPI(ctx, tx.Tx(), objectDevices, nil, nil)
+				if err != nil {
+					return err
 				}
+
+				instance.Config = instancePlacementFilterConfig(instance.Config, hiddenKeys)
+				instance.ExpandedConfig = instancePlacementFilterConfig(instance.ExpandedConfig, hiddenKeys)
+
+				instanceList = append(instanceList, *instance)
 			}
 
-			if targetMember == nil {
-				return starlark.String("Invalid member name"), nil
+			if includePending {
+				pending, err := tx.GetPendingInstances(ctx, project, location)
+				if err != nil {
+					return err
+				}
+
+				instanceList = append(instanceList, pending...)
+			}
+
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		rv, err := marshal.StarlarkMarshal(instanceList)
+		if err != nil {
+			return nil, fmt.Errorf("Marshalling instances failed: %w", err)
+		}
+
+		return rv, nil
+	}
+
+	getInstancesCountFunc := func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var projectName string
+		var locationName string
+		var includePending bool
+		var groupBy string
+		var allProjects bool
+
+		err := starlark.UnpackArgs(b.Name(), args, kwargs, "project??", &projectName, "location??", &locationName, "pending??", &includePending, "group_by??", &groupBy, "all_projects??", &allProjects)
+		if err != nil {
+			return nil, err
+		}
+
+		// Counting without a project filter spans all projects.
+		if allProjects {
+			projectName = ""
+		}
+
+		var count any
+
+		err = s.DB.Cluster.Transaction(ctx, func(ctx context.Context, tx *db.ClusterTx) error {
+			if groupBy != "" {
+				count, err = tx.GetInstancesCountBy(ctx, projectName, locationName, includePending, groupBy)
+			} else {
+				count, err = tx.GetInstancesCount(ctx, projectName, locationName, includePending)
 			}
 
-			client, err := cluster.Connect(targetMember.Address, s.Endpoints.NetworkCert(), s.ServerCert(), nil, true)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		rv, err := marshal.StarlarkMarshal(count)
+		if err != nil {
+			return nil, fmt.Errorf("Marshalling instance count failed: %w", err)
+		}
+
+		return rv, nil
+	}
+
+	leastLoadedFunc := func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var dimension string
+		var labelKey string
+		var labelValue string
+
+		err := starlark.UnpackArgs(b.Name(), args, kwargs, "dimension", &dimension, "label_key??", &labelKey, "label_value??", &labelValue)
+		if err != nil {
+			return nil, err
+		}
+
+		var instanceType instancetype.Type
+
+		switch dimension {
+		case "instances":
+			instanceType = instancetype.Any
+		case string(api.InstanceTypeContainer):
+			instanceType = instancetype.Container
+		case string(api.InstanceTypeVM):
+			instanceType = instancetype.VM
+		default:
+			return nil, fmt.Errorf("Invalid dimension %q", dimension)
+		}
+
+		if labelKey == "" && labelValue != "" {
+			return nil, fmt.Errorf("A label value requires a label key")
+		}
+
+		configKey := ""
+		if labelKey != "" {
+			configKey = "user." + labelKey
+		}
+
+		var counts map[string]int
+
+		err = s.DB.Cluster.Transaction(ctx, func(ctx context.Context, tx *db.ClusterTx) error {
+			counts, err = tx.GetInstancesCountByMember(ctx, req.Project, instanceType, configKey, labelValue)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		names := make([]string, 0, len(candidateMembers))
+		for _, member := range candidateMembers {
+			names = append(names, member.Name)
+		}
+
+		return starlark.String(instancePlacementLeastLoaded(counts, names)), nil
+	}
+
+	clusterLoadBalanceFunc := func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var metric string
+
+		err := starlark.UnpackArgs(b.Name(), args, kwargs, "metric??", &metric)
+		if err != nil {
+			return nil, err
+		}
+
+		if metric == "" {
+			metric = "instances"
+		}
+
+		loads := make(map[string]float64, len(candidateMembers))
+		deltas := make(map[string]float64, len(candidateMembers))
+
+		switch metric {
+		case "instances":
+			var counts map[string]int
+
+			err = s.DB.Cluster.Transaction(ctx, func(ctx context.Context, tx *db.ClusterTx) error {
+				counts, err = tx.GetInstancesCountByMember(ctx, "", instancetype.Any, "", "")
+				return err
+			})
 			if err != nil {
 				return nil, err
 			}
 
-			res, err = client.GetServerResources()
-			if err != nil {
-				return nil, err
-			}
+			for _, member := range candidateMembers {
+				loads[member.Name] = float64(counts[member.Name])
+				deltas[member.Name] = 1
+			}
+
+		case "cpu":
+			// Load is the one minute load average per CPU thread, a new instance keeping one thread busy.
+			for _, member := range candidateMembers {
+				// Members that can't be reached are left out of the statistics when ignoring remote errors.
+				res, err := getMemberResources(member.Name)
+				if err != nil {
+					_, err = memberError(member.Name, err)
+					if err != nil {
+						return nil, err
+					}
+
+					continue
+				}
+
+				memberState, err := getMemberState(member.Name)
+				if err != nil {
+					_, err = memberError(member.Name, err)
+					if err != nil {
+						return nil, err
+					}
+
+					continue
+				}
+
+				if res == nil || memberState == nil || res.CPU.Total == 0 || len(memberState.SysInfo.LoadAverages) == 0 {
+					continue
+				}
+
+				loads[member.Name] = memberState.SysInfo.LoadAverages[0] / float64(res.CPU.Total)
+				deltas[member.Name] = 1 / float64(res.CPU.Total)
+			}
+
+		default:
+			return nil, fmt.Errorf("Invalid metric %q", metric)
+		}
+
+		result := instancePlacementLoadBalance(loads, deltas)
+		result.Metric = metric
+
+		rv, err := marshal.StarlarkMarshal(result)
+		if err != nil {
+			return nil, fmt.Errorf("Marshalling cluster load balance failed: %w", err)
+		}
+
+		return rv, nil
+	}
+
+	getMemberInstancesFunc := func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var memberName string
+		var runningOnly bool
+
+		err := starlark.UnpackArgs(b.Name(), args, kwargs, "member_name", &memberName, "running_only??", &runningOnly)
+		if err != nil {
+			return nil, err
+		}
+
+		var objects []db.Instance
+
+		err = s.DB.Cluster.Transaction(ctx, func(ctx context.Context, tx *db.ClusterTx) error {
+			objects, err = tx.GetInstancesByMember(ctx, memberName, runningOnly)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		instances := make([]apiScriptlet.MemberInstance, 0, len(objects))
+		for _, obj := range objects {
+			instances = append(instances, apiScriptlet.MemberInstance{
+				Name:    obj.Name,
+				Project: obj.Project,
+				Type:    obj.Type.String(),
+			})
 		}
 
-		rv, err := marshal.StarlarkMarshal(res)
+		rv, err := marshal.StarlarkMarshal(instances)
 		if err != nil {
-			return nil, fmt.Errorf("Marshalling cluster member resources for %q failed: %w", memberName, err)
+			return nil, fmt.Errorf("Marshalling instances of member %q failed: %w", memberName, err)
 		}
 
 		return rv, nil
 	}
 
-	getClusterMemberStateFunc := func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
-		var memberName string
+	getInstanceLocationFunc := func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var name string
+		var projectName string
 
-		err := starlark.UnpackArgs(b.Name(), args, kwargs, "member_name", &memberName)
+		err := starlark.UnpackArgs(b.Name(), args, kwargs, "name", &name, "project??", &projectName)
 		if err != nil {
 			return nil, err
 		}
 
-		var memberState *api.ClusterMemberState
-
-		// Get the local resource usage.
-		if memberName == s.ServerName {
-			memberState, err = cluster.MemberState(ctx, s, memberName)
-			if err != nil {
-				return nil, err
-			}
-		} else {
-			// Get remote member resource usage.
-			var targetMember *db.NodeInfo
-			for i := range candidateMembers {
-				if candidateMembers[i].Name == memberName {
-					targetMember = &candidateMembers[i]
-					break
-				}
-			}
-
-			if targetMember == nil {
-				return starlark.String("Invalid member name"), nil
-			}
+		if projectName == "" {
+			projectName = req.Project
+		}
 
-			client, err := cluster.Connect(targetMember.Address, s.Endpoints.NetworkCert(), s.ServerCert(), nil, true)
-			if err != nil {
-				return nil, err
-			}
+		var objects []dbCluster.Instance
 
-			memberState, _, err = client.GetClusterMemberState(memberName)
-			if err != nil {
-				return nil, err
-			}
+		err = s.DB.Cluster.Transaction(ctx, func(ctx context.Context, tx *db.ClusterTx) error {
+			objects, err = dbCluster.GetInstances(ctx, tx.Tx(), dbCluster.InstanceFilter{Project: &projectName, Name: &name})
+			return err
+		})
+		if err != nil {
+			return nil, err
 		}
 
-		rv, err := marshal.StarlarkMarshal(memberState)
-		if err != nil {
-			return nil, fmt.Errorf("Marshalling cluster member state for %q failed: %w", memberName, err)
+		if len(objects) == 0 {
+			return starlark.None, nil
 		}
 
-		return rv, nil
+		return starlark.String(objects[0].Node), nil
 	}
 
-	getInstanceResourcesFunc := func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
-		var err error
-		var res apiScriptlet.InstanceResources
+	instanceExistsFunc := func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var name string
+		var projectName string
 
-		usageCPU, usageMemory, usageDisk, err := internalInstance.ResourceUsage(req.Config, req.Devices, req.Type)
+		err := starlark.UnpackArgs(b.Name(), args, kwargs, "name", &name, "project??", &projectName)
 		if err != nil {
-			return nil, fmt.Errorf("Failed to calculate instance resource usage: %w", err)
+			return nil, err
 		}
 
-		res.CPUCores = uint64(usageCPU)
-		res.MemorySize = uint64(usageMemory)
-		res.RootDiskSize = uint64(usageDisk)
+		if projectName == "" {
+			projectName = req.Project
+		}
 
-		rv, err := marshal.StarlarkMarshal(res)
+		var exists bool
+
+		err = s.DB.Cluster.Transaction(ctx, func(ctx context.Context, tx *db.ClusterTx) error {
+			exists, err = dbCluster.InstanceExists(ctx, tx.Tx(), projectName, name)
+			return err
+		})
 		if err != nil {
-			return nil, fmt.Errorf("Marshalling instance resources failed: %w", err)
+			return nil, err
 		}
 
-		return rv, nil
+		return starlark.Bool(exists), nil
 	}
 
-	getInstancesFunc := func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
-		var project string
-		var location string
-
-		err := starlark.UnpackArgs(b.Name(), args, kwargs, "project??", &project, "location??", &location)
+	nowFunc := func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		err := starlark.UnpackArgs(b.Name(), args, kwargs)
 		if err != nil {
 			return nil, err
 		}
 
-		instanceList := []api.Instance{}
+		now := instancePlacementNow().UTC()
+
+		rv, err := marshal.StarlarkMarshal(apiScriptlet.CurrentTime{
+			Unix:    now.Unix(),
+			Year:    now.Year(),
+			Month:   int(now.Month()),
+			Day:     now.Day(),
+			Hour:    now.Hour(),
+			Minute:  now.Minute(),
+			Second:  now.Second(),
+			Weekday: int(now.Weekday()),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Marshalling current time failed: %w", err)
+		}
 
-		err = s.DB.Cluster.Transaction(ctx, func(ctx context.Context, tx *db.ClusterTx) error {
-			var objects []dbCluster.Instance
+		return rv, nil
+	}
 
-			if project != "" || location != "" {
-				// Prepare a filter.
-				filter := dbCluster.InstanceFilter{}
+	memberHasKernelFeatureFunc := func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var memberName string
+		var feature string
 
-				if project != "" {
-					filter.Project = &project
-				}
+		err := starlark.UnpackArgs(b.Name(), args, kwargs, "member_name", &memberName, "feature", &feature)
+		if err != nil {
+			return nil, err
+		}
 
-				if location != "" {
-					filter.Node = &location
-				}
+		// Only the features reported in the server environment can be checked, an unknown one would look missing.
+		_, ok := s.OS.KernelFeatures()[feature]
+		if !ok {
+			return nil, fmt.Errorf("Unknown kernel feature %q", feature)
+		}
 
-				// Get instances based on Project and/or Location filters.
-				objects, err = dbCluster.GetInstances(ctx, tx.Tx(), filter)
-				if err != nil {
-					return err
-				}
-			} else {
-				// Get all instances.
-				objects, err = dbCluster.GetInstances(ctx, tx.Tx())
-				if err != nil {
-					return err
-				}
+		var kernelFeatures map[string]string
+
+		if memberName == s.ServerName {
+			// Get the local kernel features.
+			kernelFeatures = s.OS.KernelFeatures()
+		} else {
+			// Get the remote kernel features from the member's server environment.
+			targetMember := getCandidateMember(memberName)
+			if targetMember == nil {
+				return starlark.String("Invalid member name"), nil
 			}
 
-			objectDevices, err := dbCluster.GetDevices(ctx, tx.Tx(), "instance")
+			client, err := connectMember(*targetMember)
 			if err != nil {
-				return err
+				return memberError(memberName, err)
 			}
 
-			// Convert the []Instances into []api.Instances.
-			for _, obj := range objects {
-				instance, err := obj.ToAPI(ctx, tx.Tx(), objectDevices, nil, nil)
-				if err != nil {
-					return err
-				}
-
-				instanceList = append(instanceList, *instance)
+			server, _, err := client.GetServer()
+			if err != nil {
+				return memberError(memberName, err)
 			}
 
-			return nil
-		})
-		if err != nil {
-			return nil, err
+			kernelFeatures = server.Environment.KernelFeatures
 		}
 
-		rv, err := marshal.StarlarkMarshal(instanceList)
+		return starlark.Bool(kernelFeatures[feature] == "true"), nil
+	}
+
+	isLocalMemberFunc := func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var memberName string
+
+		err := starlark.UnpackArgs(b.Name(), args, kwargs, "member_name", &memberName)
 		if err != nil {
-			return nil, fmt.Errorf("Marshalling instances failed: %w", err)
+			return nil, err
 		}
 
-		return rv, nil
+		return starlark.Bool(memberName == s.ServerName), nil
 	}
 
-	getInstancesCountFunc := func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	areColocatedFunc := func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var namesList *starlark.List
 		var projectName string
-		var locationName string
-		var includePending bool
 
-		err := starlark.UnpackArgs(b.Name(), args, kwargs, "project??", &projectName, "location??", &locationName, "pending??", &includePending)
+		err := starlark.UnpackArgs(b.Name(), args, kwargs, "instance_names", &namesList, "project??", &projectName)
 		if err != nil {
 			return nil, err
 		}
 
-		var count int
+		if projectName == "" {
+			projectName = req.Project
+		}
+
+		// Build a filter for each instance so they all get retrieved in a single query.
+		filters := make([]dbCluster.InstanceFilter, 0, namesList.Len())
+		for i := 0; i < namesList.Len(); i++ {
+			name, ok := starlark.AsString(namesList.Index(i))
+			if !ok {
+				return nil, fmt.Errorf("Invalid instance name: %v", namesList.Index(i))
+			}
+
+			filters = append(filters, dbCluster.InstanceFilter{Project: &projectName, Name: &name})
+		}
+
+		if len(filters) == 0 {
+			return starlark.None, nil
+		}
+
+		var objects []dbCluster.Instance
 
 		err = s.DB.Cluster.Transaction(ctx, func(ctx context.Context, tx *db.ClusterTx) error {
-			count, err = tx.GetInstancesCount(ctx, projectName, locationName, includePending)
+			objects, err = dbCluster.GetInstances(ctx, tx.Tx(), filters...)
 			return err
 		})
 		if err != nil {
 			return nil, err
 		}
 
-		rv, err := marshal.StarlarkMarshal(count)
-		if err != nil {
-			return nil, fmt.Errorf("Marshalling instance count failed: %w", err)
+		// Check that all the instances exist.
+		for _, filter := range filters {
+			if !slices.ContainsFunc(objects, func(object dbCluster.Instance) bool { return object.Name == *filter.Name }) {
+				return nil, fmt.Errorf("Instance %q not found in project %q", *filter.Name, projectName)
+			}
 		}
 
-		return rv, nil
+		for _, object := range objects {
+			if object.Node != objects[0].Node {
+				return starlark.None, nil
+			}
+		}
+
+		return starlark.String(objects[0].Node), nil
 	}
 
 	getClusterMembersFunc := func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
 		var group string
+		var offlineSeconds starlark.Value
 		var allMembers []db.NodeInfo
 
-		err := starlark.UnpackArgs(b.Name(), args, kwargs, "group??", &group)
+		err := starlark.UnpackArgs(b.Name(), args, kwargs, "group??", &group, "offline_seconds??", &offlineSeconds)
 		if err != nil {
 			return nil, err
 		}
 
+		// Allow the scriptlet to use a stricter offline threshold than the global one.
+		// Looser values are capped to the global threshold so members considered offline are never returned.
+		offlineThreshold := s.GlobalConfig.OfflineThreshold()
+		if offlineSeconds != nil && offlineSeconds != starlark.None {
+			seconds, err := starlark.AsInt32(offlineSeconds)
+			if err != nil || seconds <= 0 {
+				return nil, fmt.Errorf("Invalid offline_seconds %v: Must be a positive integer", offlineSeconds)
+			}
+
+			offlineThreshold = min(offlineThreshold, time.Duration(seconds)*time.Second)
+		}
+
 		err = s.DB.Cluster.Transaction(ctx, func(ctx context.Context, tx *db.ClusterTx) error {
 			allMembers, err = tx.GetNodes(ctx)
 			if err != nil {
 				return err
 			}
 
-			allMembers, err = tx.GetCandidateMembers(ctx, allMembers, nil, group, nil, s.GlobalConfig.OfflineThreshold())
+			allMembers, err = tx.GetCandidateMembers(ctx, allMembers, nil, group, nil, offlineThreshold)
 			if err != nil {
 				return err
 			}
@@ -329,7 +2666,7 @@ func InstancePlacementRun(ctx context.Context, l logger.Logger, s *state.State,
 				LeaderAddress:        leaderAddress,
 				FailureDomains:       failureDomains,
 				MemberFailureDomains: memberFailureDomains,
-				OfflineThreshold:     s.GlobalConfig.OfflineThreshold(),
+				OfflineThreshold:     offlineThreshold,
 				MaxMemberVersion:     maxVersion,
 				RaftNodes:            raftNodes,
 			}
@@ -392,6 +2729,102 @@ func InstancePlacementRun(ctx context.Context, l logger.Logger, s *state.State,
 		return rv, nil
 	}
 
+	getProjectsFunc := func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		err := starlark.UnpackArgs(b.Name(), args, kwargs)
+		if err != nil {
+			return nil, err
+		}
+
+		var projects []api.Project
+
+		err = s.DB.Cluster.Transaction(ctx, func(ctx context.Context, tx *db.ClusterTx) error {
+			dbProjects, err := dbCluster.GetProjects(ctx, tx.Tx())
+			if err != nil {
+				return err
+			}
+
+			// Load the config of all projects at once rather than expanding each project.
+			configs, err := dbCluster.GetConfig(ctx, tx.Tx(), "project")
+			if err != nil {
+				return err
+			}
+
+			projects = make([]api.Project, 0, len(dbProjects))
+			for _, dbProject := range dbProjects {
+				p := api.Project{Name: dbProject.Name}
+				p.Config = map[string]string{}
+
+				// Only the limits are relevant to placement.
+				for key, value := range configs[dbProject.ID] {
+					if strings.HasPrefix(key, "limits.") {
+						p.Config[key] = value
+					}
+				}
+
+				projects = append(projects, p)
+			}
+
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		rv, err := marshal.StarlarkMarshal(projects)
+		if err != nil {
+			return nil, fmt.Errorf("Marshalling projects failed: %w", err)
+		}
+
+		return rv, nil
+	}
+
+	getProjectProfilesFunc := func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var name string
+
+		err := starlark.UnpackArgs(b.Name(), args, kwargs, "name??", &name)
+		if err != nil {
+			return nil, err
+		}
+
+		if name == "" {
+			name = req.Project
+		}
+
+		var profiles []api.Profile
+
+		err = s.DB.Cluster.Transaction(ctx, func(ctx context.Context, tx *db.ClusterTx) error {
+			// All the profiles usable in the project, not only those applied to new instances by default.
+			// Profiles come from the default project unless the project has features.profiles enabled.
+			profileNames, err := tx.GetProfileNames(ctx, name)
+			if err != nil {
+				return err
+			}
+
+			sort.Strings(profileNames)
+
+			profiles, err = tx.GetProfiles(ctx, name, profileNames)
+			if err != nil {
+				return err
+			}
+
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range profiles {
+			profiles[i].Config = instancePlacementFilterConfig(profiles[i].Config, hiddenKeys)
+		}
+
+		rv, err := marshal.StarlarkMarshal(profiles)
+		if err != nil {
+			return nil, fmt.Errorf("Marshalling profiles failed: %w", err)
+		}
+
+		return rv, nil
+	}
+
 	var err error
 	var raftNodes []db.RaftNode
 	err = s.DB.Node.Transaction(ctx, func(ctx context.Context, tx *db.NodeTx) error {
@@ -450,17 +2883,58 @@ func InstancePlacementRun(ctx context.Context, l logger.Logger, s *state.State,
 	// Remember to match the entries in scriptletLoad.InstancePlacementCompile() with this list so Starlark can
 	// perform compile time validation of functions used.
 	env := starlark.StringDict{
-		"log_info":                     starlark.NewBuiltin("log_info", logFunc),
-		"log_warn":                     starlark.NewBuiltin("log_warn", logFunc),
-		"log_error":                    starlark.NewBuiltin("log_error", logFunc),
-		"set_target":                   starlark.NewBuiltin("set_target", setTargetFunc),
-		"get_cluster_member_resources": starlark.NewBuiltin("get_cluster_member_resources", getClusterMemberResourcesFunc),
-		"get_cluster_member_state":     starlark.NewBuiltin("get_cluster_member_state", getClusterMemberStateFunc),
-		"get_instance_resources":       starlark.NewBuiltin("get_instance_resources", getInstanceResourcesFunc),
-		"get_instances":                starlark.NewBuiltin("get_instances", getInstancesFunc),
-		"get_instances_count":          starlark.NewBuiltin("get_instances_count", getInstancesCountFunc),
-		"get_cluster_members":          starlark.NewBuiltin("get_cluster_members", getClusterMembersFunc),
-		"get_project":                  starlark.NewBuiltin("get_project", getProjectFunc),
+		"log_info":                          starlark.NewBuiltin("log_info", logFunc),
+		"log_warn":                          starlark.NewBuiltin("log_warn", logFunc),
+		"log_error":                         starlark.NewBuiltin("log_error", logFunc),
+		"set_target":                        starlark.NewBuiltin("set_target", setTargetFunc),
+		"set_targets":                       starlark.NewBuiltin("set_targets", setTargetsFunc),
+		"reject_placement":                  starlark.NewBuiltin("reject_placement", rejectPlacementFunc),
+		"request_retry":                     starlark.NewBuiltin("request_retry", requestRetryFunc),
+		"set_config_override":               starlark.NewBuiltin("set_config_override", setConfigOverrideFunc),
+		"choose_weighted":                   starlark.NewBuiltin("choose_weighted", chooseWeightedFunc),
+		"rendezvous_hash":                   starlark.NewBuiltin("rendezvous_hash", rendezvousHashFunc),
+		"get_random":                        starlark.NewBuiltin("get_random", getRandomFunc),
+		"now":                               starlark.NewBuiltin("now", nowFunc),
+		"get_cluster_member_resources":      starlark.NewBuiltin("get_cluster_member_resources", getClusterMemberResourcesFunc),
+		"get_cluster_resources":             starlark.NewBuiltin("get_cluster_resources", getClusterResourcesFunc),
+		"get_cluster_limits":                starlark.NewBuiltin("get_cluster_limits", getClusterLimitsFunc),
+		"get_cluster_member_state":          starlark.NewBuiltin("get_cluster_member_state", getClusterMemberStateFunc),
+		"get_member_gpus":                   starlark.NewBuiltin("get_member_gpus", getMemberGPUsFunc),
+		"get_member_hugepages":              starlark.NewBuiltin("get_member_hugepages", getMemberHugepagesFunc),
+		"get_member_network_ports":          starlark.NewBuiltin("get_member_network_ports", getMemberNetworkPortsFunc),
+		"get_member_maintenance":            starlark.NewBuiltin("get_member_maintenance", getMemberMaintenanceFunc),
+		"get_cluster_member_roles":          starlark.NewBuiltin("get_cluster_member_roles", getClusterMemberRolesFunc),
+		"is_local_member":                   starlark.NewBuiltin("is_local_member", isLocalMemberFunc),
+		"member_failure_domain":             starlark.NewBuiltin("member_failure_domain", memberFailureDomainFunc),
+		"domain_density":                    starlark.NewBuiltin("domain_density", domainDensityFunc),
+		"get_ovn_chassis":                   starlark.NewBuiltin("get_ovn_chassis", getOVNChassisFunc),
+		"member_fits":                       starlark.NewBuiltin("member_fits", memberFitsFunc),
+		"can_live_migrate":                  starlark.NewBuiltin("can_live_migrate", canLiveMigrateFunc),
+		"member_supports_arch":              starlark.NewBuiltin("member_supports_arch", memberSupportsArchFunc),
+		"member_supports_memory_hotplug":    starlark.NewBuiltin("member_supports_memory_hotplug", memberSupportsMemoryHotplugFunc),
+		"member_has_kernel_feature":         starlark.NewBuiltin("member_has_kernel_feature", memberHasKernelFeatureFunc),
+		"get_member_metrics":                starlark.NewBuiltin("get_member_metrics", getMemberMetricsFunc),
+		"get_member_autostart_failures":     starlark.NewBuiltin("get_member_autostart_failures", getMemberAutostartFailuresFunc),
+		"get_storage_pool_driver":           starlark.NewBuiltin("get_storage_pool_driver", getStoragePoolDriverFunc),
+		"pool_free_space":                   starlark.NewBuiltin("pool_free_space", poolFreeSpaceFunc),
+		"get_instance_resources":            starlark.NewBuiltin("get_instance_resources", getInstanceResourcesFunc),
+		"get_instance_device_counts":        starlark.NewBuiltin("get_instance_device_counts", getInstanceDeviceCountsFunc),
+		"get_instance_network_requirements": starlark.NewBuiltin("get_instance_network_requirements", getInstanceNetworkRequirementsFunc),
+		"get_instance_config_sources":       starlark.NewBuiltin("get_instance_config_sources", getInstanceConfigSourcesFunc),
+		"get_instance_snapshots":            starlark.NewBuiltin("get_instance_snapshots", getInstanceSnapshotsFunc),
+		"get_instance_volumes":              starlark.NewBuiltin("get_instance_volumes", getInstanceVolumesFunc),
+		"get_instances":                     starlark.NewBuiltin("get_instances", getInstancesFunc),
+		"get_instances_count":               starlark.NewBuiltin("get_instances_count", getInstancesCountFunc),
+		"least_loaded":                      starlark.NewBuiltin("least_loaded", leastLoadedFunc),
+		"cluster_load_balance":              starlark.NewBuiltin("cluster_load_balance", clusterLoadBalanceFunc),
+		"get_instance_location":             starlark.NewBuiltin("get_instance_location", getInstanceLocationFunc),
+		"get_member_instances":              starlark.NewBuiltin("get_member_instances", getMemberInstancesFunc),
+		"instance_exists":                   starlark.NewBuiltin("instance_exists", instanceExistsFunc),
+		"are_colocated":                     starlark.NewBuiltin("are_colocated", areColocatedFunc),
+		"get_cluster_members":               starlark.NewBuiltin("get_cluster_members", getClusterMembersFunc),
+		"get_project":                       starlark.NewBuiltin("get_project", getProjectFunc),
+		"get_projects":                      starlark.NewBuiltin("get_projects", getProjectsFunc),
+		"get_project_profiles":              starlark.NewBuiltin("get_project_profiles", getProjectProfilesFunc),
 	}
 
 	prog, thread, err := scriptletLoad.InstancePlacementProgram()
@@ -475,18 +2949,42 @@ func InstancePlacementRun(ctx context.Context, l logger.Logger, s *state.State,
 
 	globals, err := prog.Init(thread, env)
 	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("Timed out after %s while initializing", timeout)
+		}
+
 		return nil, fmt.Errorf("Failed initializing: %w", err)
 	}
 
 	globals.Freeze()
 
+	// Let the scriptlet skip unreachable members rather than failing the whole run.
+	ignoreRemoteErrorsValue := globals["ignore_remote_errors"]
+	ignoreRemoteErrors = ignoreRemoteErrorsValue != nil && bool(ignoreRemoteErrorsValue.Truth())
+
 	// Retrieve a global variable from starlark environment.
 	instancePlacement := globals["instance_placement"]
 	if instancePlacement == nil {
 		return nil, fmt.Errorf("Scriptlet missing instance_placement function")
 	}
 
-	rv, err := marshal.StarlarkMarshal(req)
+	// Copy the request so the filtered config, labels and boot priority don't end up in the caller's request.
+	reqCopy := *req
+	reqCopy.Config = instancePlacementFilterConfig(req.Config, hiddenKeys)
+	reqCopy.ConfigSources = instancePlacementFilterConfig(req.ConfigSources, hiddenKeys)
+	if reqCopy.Labels == nil {
+		reqCopy.Labels = instancePlacementLabels(reqCopy.Config)
+	}
+
+	if reqCopy.BootPriority == "" {
+		reqCopy.BootPriority = reqCopy.Config["boot.autostart.priority"]
+	}
+
+	if reqCopy.Priority == "" {
+		reqCopy.Priority = reqCopy.Config["user.priority"]
+	}
+
+	rv, err := marshal.StarlarkMarshal(reqCopy)
 	if err != nil {
 		return nil, fmt.Errorf("Marshalling request failed: %w", err)
 	}
@@ -507,12 +3005,81 @@ func InstancePlacementRun(ctx context.Context, l logger.Logger, s *state.State,
 		},
 	})
 	if err != nil {
+		if rejected != nil {
+			return nil, *rejected
+		}
+
+		if retry != nil {
+			return nil, *retry
+		}
+
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("Timed out after %s while running", timeout)
+		}
+
 		return nil, fmt.Errorf("Failed to run: %w", err)
 	}
 
-	if v.Type() != "NoneType" {
+	switch v := v.(type) {
+	case starlark.NoneType:
+	case *starlark.Dict:
+		// The scriptlet returned scores for the cluster members, pick the highest scored candidate.
+		if targetMember != nil {
+			return nil, fmt.Errorf("Failed with both a member target and scores set")
+		}
+
+		scores := make(map[string]float64, v.Len())
+		for _, item := range v.Items() {
+			memberName, ok := starlark.AsString(item[0])
+			if !ok {
+				return nil, fmt.Errorf("Failed with invalid member name in scores: %v", item[0])
+			}
+
+			score, ok := starlark.AsFloat(item[1])
+			if !ok {
+				return nil, fmt.Errorf("Failed with invalid score for member %q: %v", memberName, item[1])
+			}
+
+			// Ignore members which aren't candidates.
+			if !slices.ContainsFunc(candidateMembers, func(member db.NodeInfo) bool { return member.Name == memberName }) {
+				continue
+			}
+
+			scores[memberName] = score
+		}
+
+		memberName := instancePlacementBestScore(scores)
+		for i := range candidateMembers {
+			if candidateMembers[i].Name == memberName {
+				targetMember = &candidateMembers[i]
+				break
+			}
+		}
+
+		if targetMember != nil {
+			l.Info("Instance placement scriptlet scored member target", logger.Ctx{"member": targetMember.Name, "score": scores[memberName]})
+		}
+
+	default:
 		return nil, fmt.Errorf("Failed with unexpected return value: %v", v)
 	}
 
-	return targetMember, nil
+	// Record whether the scriptlet picked a member or left it to the built-in placement logic.
+	memberName := ""
+	if targetMember != nil {
+		memberName = targetMember.Name
+	}
+
+	s.Events.SendLifecycle(req.Project, lifecycle.InstancePlaced.Event(req.Name, req.Project, map[string]any{
+		"member":    memberName,
+		"reason":    req.Reason,
+		"scriptlet": prog.Filename(),
+		"skipped":   false,
+	}))
+
+	if targets == nil && targetMember != nil {
+		targets = []InstancePlacementTarget{{Member: targetMember, Pool: selectedPool}}
+	}
+
+	return &InstancePlacementResult{Member: targetMember, ConfigOverrides: configOverrides, Pool: selectedPool, RemoteErrors: remoteErrors, Targets: targets}, nil
 }
@@ -0,0 +1,2389 @@
+package scriptlet
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	dqliteClient "github.com/cowsql/go-cowsql/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	incus "github.com/lxc/incus/v6/client"
+	clusterConfig "github.com/lxc/incus/v6/internal/server/cluster/config"
+	"github.com/lxc/incus/v6/internal/server/db"
+	dbCluster "github.com/lxc/incus/v6/internal/server/db/cluster"
+	"github.com/lxc/incus/v6/internal/server/db/operationtype"
+	"github.com/lxc/incus/v6/internal/server/db/warningtype"
+	"github.com/lxc/incus/v6/internal/server/events"
+	"github.com/lxc/incus/v6/internal/server/instance/instancetype"
+	"github.com/lxc/incus/v6/internal/server/metrics"
+	scriptletLoad "github.com/lxc/incus/v6/internal/server/scriptlet/load"
+	"github.com/lxc/incus/v6/internal/server/state"
+	"github.com/lxc/incus/v6/shared/api"
+	apiScriptlet "github.com/lxc/incus/v6/shared/api/scriptlet"
+	"github.com/lxc/incus/v6/shared/logger"
+	"github.com/lxc/incus/v6/shared/osarch"
+)
+
+// setupInstancePlacement loads the given scriptlet and returns a test state along with the cluster members.
+func setupInstancePlacement(t *testing.T, src string) (*state.State, []db.NodeInfo) {
+	s, cleanup := state.NewTestState(t)
+	t.Cleanup(cleanup)
+
+	s.ServerName = "none"
+	s.Events = events.NewServer(false, false, nil)
+
+	var members []db.NodeInfo
+	err := s.DB.Cluster.Transaction(context.Background(), func(ctx context.Context, tx *db.ClusterTx) error {
+		_, err := tx.CreateNode("node2", "10.0.0.2:8443")
+		if err != nil {
+			return err
+		}
+
+		s.GlobalConfig, err = clusterConfig.Load(ctx, tx)
+		if err != nil {
+			return err
+		}
+
+		members, err = tx.GetNodes(ctx)
+		return err
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, scriptletLoad.InstancePlacementSet(src))
+	t.Cleanup(func() { _ = scriptletLoad.InstancePlacementSet("") })
+
+	return s, members
+}
+
+// createInstancePlacementInstance creates an instance record on the given cluster member.
+func createInstancePlacementInstance(t *testing.T, s *state.State, projectName string, name string, memberName string, config map[string]string) {
+	err := s.DB.Cluster.Transaction(context.Background(), func(ctx context.Context, tx *db.ClusterTx) error {
+		id, err := dbCluster.CreateInstance(ctx, tx.Tx(), dbCluster.Instance{
+			Project:      projectName,
+			Name:         name,
+			Node:         memberName,
+			Type:         instancetype.Container,
+			Architecture: 1,
+		})
+		if err != nil {
+			return err
+		}
+
+		return dbCluster.CreateInstanceConfig(ctx, tx.Tx(), id, config)
+	})
+	require.NoError(t, err)
+}
+
+// newInstancePlacementRequest returns a basic instance placement request.
+func newInstancePlacementRequest() *apiScriptlet.InstancePlacement {
+	return &apiScriptlet.InstancePlacement{
+		InstancesPost: api.InstancesPost{
+			Name: "c1",
+			Type: api.InstanceTypeContainer,
+			InstancePut: api.InstancePut{
+				Config:  map[string]string{},
+				Devices: map[string]map[string]string{},
+			},
+		},
+		Project: api.ProjectDefaultName,
+		Reason:  apiScriptlet.InstancePlacementReasonNew,
+	}
+}
+
+func TestInstancePlacementRun_SetTarget(t *testing.T) {
+	s, members := setupInstancePlacement(t, `
+def instance_placement(request, candidate_members):
+    set_target("node2")
+`)
+
+	placement, err := InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members, "")
+	require.NoError(t, err)
+	require.NotNil(t, placement.Member)
+	assert.Equal(t, "node2", placement.Member.Name)
+}
+
+// instancePlacementLogEntry is a log line recorded by instancePlacementTestLogger.
+type instancePlacementLogEntry struct {
+	msg string
+	ctx logger.Ctx
+}
+
+// instancePlacementTestLogger records the lines logged through it along with their context.
+type instancePlacementTestLogger struct {
+	ctx     logger.Ctx
+	entries *[]instancePlacementLogEntry
+}
+
+func (l *instancePlacementTestLogger) record(msg string, args ...logger.Ctx) {
+	ctx := logger.Ctx{}
+	for _, c := range append([]logger.Ctx{l.ctx}, args...) {
+		for k, v := range c {
+			ctx[k] = v
+		}
+	}
+
+	*l.entries = append(*l.entries, instancePlacementLogEntry{msg: msg, ctx: ctx})
+}
+
+func (l *instancePlacementTestLogger) Panic(msg string, args ...logger.Ctx) { l.record(msg, args...) }
+func (l *instancePlacementTestLogger) Fatal(msg string, args ...logger.Ctx) { l.record(msg, args...) }
+func (l *instancePlacementTestLogger) Error(msg string, args ...logger.Ctx) { l.record(msg, args...) }
+func (l *instancePlacementTestLogger) Warn(msg string, args ...logger.Ctx)  { l.record(msg, args...) }
+func (l *instancePlacementTestLogger) Info(msg string, args ...logger.Ctx)  { l.record(msg, args...) }
+func (l *instancePlacementTestLogger) Debug(msg string, args ...logger.Ctx) { l.record(msg, args...) }
+func (l *instancePlacementTestLogger) Trace(msg string, args ...logger.Ctx) { l.record(msg, args...) }
+
+func (l *instancePlacementTestLogger) AddContext(ctx logger.Ctx) logger.Logger {
+	merged := logger.Ctx{}
+	for k, v := range l.ctx {
+		merged[k] = v
+	}
+
+	for k, v := range ctx {
+		merged[k] = v
+	}
+
+	return &instancePlacementTestLogger{ctx: merged, entries: l.entries}
+}
+
+func TestInstancePlacementRun_LogContext(t *testing.T) {
+	s, members := setupInstancePlacement(t, `
+def instance_placement(request, candidate_members):
+    log_info("Placing ", request.name)
+    log_warn("Still placing")
+`)
+
+	req := newInstancePlacementRequest()
+	req.Project = "p1"
+
+	entries := []instancePlacementLogEntry{}
+	_, err := InstancePlacementRun(context.Background(), &instancePlacementTestLogger{entries: &entries}, s, req, members, "")
+	require.NoError(t, err)
+
+	var scriptletEntries []instancePlacementLogEntry
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.msg, "Instance placement scriptlet: ") {
+			scriptletEntries = append(scriptletEntries, entry)
+		}
+	}
+
+	require.Len(t, scriptletEntries, 2)
+	assert.Equal(t, "Instance placement scriptlet: Placing c1", scriptletEntries[0].msg)
+
+	for _, entry := range scriptletEntries {
+		assert.Equal(t, "c1", entry.ctx["instance"])
+		assert.Equal(t, "p1", entry.ctx["project"])
+	}
+}
+
+// Test that a lifecycle event records the member chosen by the scriptlet.
+func TestInstancePlacementRun_LifecycleEvent(t *testing.T) {
+	s, members := setupInstancePlacement(t, `
+def instance_placement(request, candidate_members):
+    set_target("node2")
+`)
+
+	var lifecycleEvents []api.EventLifecycle
+	s.Events = events.NewServer(false, false, func(event api.Event) {
+		if event.Type != api.EventTypeLifecycle {
+			return
+		}
+
+		lifecycleEvent := api.EventLifecycle{}
+		err := json.Unmarshal(event.Metadata, &lifecycleEvent)
+		if err != nil {
+			return
+		}
+
+		lifecycleEvents = append(lifecycleEvents, lifecycleEvent)
+	})
+
+	_, err := InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members, "")
+	require.NoError(t, err)
+	require.Len(t, lifecycleEvents, 1)
+
+	event := lifecycleEvents[0]
+	assert.Equal(t, api.EventLifecycleInstancePlaced, event.Action)
+	assert.Equal(t, "c1", event.Name)
+	assert.Equal(t, api.ProjectDefaultName, event.Project)
+	assert.Equal(t, "node2", event.Context["member"])
+	assert.Equal(t, apiScriptlet.InstancePlacementReasonNew, event.Context["reason"])
+	assert.Equal(t, "instance_placement", event.Context["scriptlet"])
+	assert.Equal(t, false, event.Context["skipped"])
+
+	// Leaving the target unset is reported with an empty member.
+	require.NoError(t, scriptletLoad.InstancePlacementSet(`
+def instance_placement(request, candidate_members):
+    pass
+`))
+
+	_, err = InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members, "")
+	require.NoError(t, err)
+	require.Len(t, lifecycleEvents, 2)
+	assert.Equal(t, "", lifecycleEvents[1].Context["member"])
+}
+
+func TestInstancePlacementRun_RejectPlacement(t *testing.T) {
+	s, members := setupInstancePlacement(t, `
+def instance_placement(request, candidate_members):
+    reject_placement("No GPU available for " + request.name)
+`)
+
+	placement, err := InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members, "")
+	assert.Nil(t, placement)
+
+	var rejectedErr ErrInstancePlacementRejected
+	require.True(t, errors.As(err, &rejectedErr))
+	assert.Equal(t, "No GPU available for c1", rejectedErr.Message)
+	assert.Equal(t, "Placement rejected: No GPU available for c1", err.Error())
+}
+
+func TestInstancePlacementRun_RequestRetry(t *testing.T) {
+	s, members := setupInstancePlacement(t, `
+def instance_placement(request, candidate_members):
+    request_retry(2)
+`)
+
+	placement, err := InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members, "")
+	assert.Nil(t, placement)
+
+	var retryErr ErrInstancePlacementRetry
+	require.True(t, errors.As(err, &retryErr))
+	assert.Equal(t, 2*time.Second, retryErr.After)
+
+	// Retries are honored a bounded number of times.
+	var waits []time.Duration
+	oldWait := instancePlacementRetryWait
+	instancePlacementRetryWait = func(ctx context.Context, after time.Duration) error {
+		waits = append(waits, after)
+		return nil
+	}
+
+	t.Cleanup(func() { instancePlacementRetryWait = oldWait })
+
+	placement, err = InstancePlacementRunWithRetry(context.Background(), logger.Log, s, newInstancePlacementRequest(), members, "")
+	assert.Nil(t, placement)
+	assert.True(t, errors.As(err, &retryErr))
+	assert.Equal(t, []time.Duration{2 * time.Second, 2 * time.Second, 2 * time.Second}, waits)
+
+	// Retries that can't run before the deadline aren't waited for.
+	waits = nil
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	_, err = InstancePlacementRunWithRetry(ctx, logger.Log, s, newInstancePlacementRequest(), members, "")
+	assert.True(t, errors.As(err, &retryErr))
+	assert.Empty(t, waits)
+
+	// Out of range delays are rejected.
+	err = scriptletLoad.InstancePlacementSet(`
+def instance_placement(request, candidate_members):
+    request_retry(60)
+`)
+	require.NoError(t, err)
+
+	_, err = InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members, "")
+	require.Error(t, err)
+	assert.False(t, errors.As(err, &retryErr))
+}
+
+func TestInstancePlacementRun_Failure(t *testing.T) {
+	s, members := setupInstancePlacement(t, `
+def instance_placement(request, candidate_members):
+    fail("Something broke")
+`)
+
+	_, err := InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members, "")
+	require.Error(t, err)
+
+	var rejectedErr ErrInstancePlacementRejected
+	assert.False(t, errors.As(err, &rejectedErr))
+}
+
+func TestInstancePlacementRun_ConfigOverrides(t *testing.T) {
+	s, members := setupInstancePlacement(t, `
+def instance_placement(request, candidate_members):
+    set_config_override("user.rack", "r1")
+    set_config_override("boot.autostart.priority", "10")
+`)
+
+	placement, err := InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members, "")
+	require.NoError(t, err)
+	assert.Nil(t, placement.Member)
+	assert.Equal(t, map[string]string{"user.rack": "r1", "boot.autostart.priority": "10"}, placement.ConfigOverrides)
+
+	// Keys outside of the allowed set are refused.
+	s, members = setupInstancePlacement(t, `
+def instance_placement(request, candidate_members):
+    set_config_override("security.privileged", "true")
+`)
+
+	_, err = InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members, "")
+	require.Error(t, err)
+
+	// Overrides are refused when moving an existing instance.
+	s, members = setupInstancePlacement(t, `
+def instance_placement(request, candidate_members):
+    set_config_override("user.rack", "r1")
+`)
+
+	for _, reason := range []string{apiScriptlet.InstancePlacementReasonRelocation, apiScriptlet.InstancePlacementReasonEvacuation} {
+		req := newInstancePlacementRequest()
+		req.Reason = reason
+
+		_, err = InstancePlacementRun(context.Background(), logger.Log, s, req, members, "")
+		assert.ErrorContains(t, err, "only supported when placing a new instance")
+	}
+}
+
+func TestInstancePlacementMemberGPUs(t *testing.T) {
+	res := &api.Resources{}
+	res.GPU.Cards = []api.ResourcesGPUCard{{
+		PCIAddress: "0000:01:00.0",
+		Vendor:     "NVIDIA Corporation",
+		VendorID:   "10de",
+		Driver:     "nvidia",
+		NUMANode:   1,
+		SRIOV:      &api.ResourcesGPUCardSRIOV{CurrentVFs: 2, MaximumVFs: 8},
+		Mdev: map[string]api.ResourcesGPUCardMdev{
+			"nvidia-1": {Available: 2},
+			"nvidia-2": {Available: 1},
+		},
+	}, {
+		PCIAddress: "0000:02:00.0",
+		Vendor:     "Intel Corporation",
+		Driver:     "i915",
+	}}
+
+	gpus := instancePlacementMemberGPUs(res)
+	require.Len(t, gpus, 2)
+	assert.Equal(t, apiScriptlet.MemberGPU{
+		PCIAddress:    "0000:01:00.0",
+		Vendor:        "NVIDIA Corporation",
+		VendorID:      "10de",
+		Driver:        "nvidia",
+		NUMANode:      1,
+		VFsCurrent:    2,
+		VFsMaximum:    8,
+		MdevAvailable: 3,
+	}, gpus[0])
+	assert.Equal(t, "0000:02:00.0", gpus[1].PCIAddress)
+	assert.Equal(t, uint64(0), gpus[1].VFsMaximum)
+}
+
+func TestInstancePlacementMemberNetworkPorts(t *testing.T) {
+	res := &api.Resources{}
+	res.Network.Cards = []api.ResourcesNetworkCard{{
+		PCIAddress: "0000:0d:00.0",
+		Driver:     "mlx5_core",
+		NUMANode:   1,
+		SRIOV: &api.ResourcesNetworkCardSRIOV{
+			CurrentVFs: 4,
+			MaximumVFs: 64,
+			VFs: []api.ResourcesNetworkCard{
+				{PCIAddress: "0000:0d:00.2", Ports: []api.ResourcesNetworkCardPort{{ID: "enp13s0f0v0"}}},
+				{PCIAddress: "0000:0d:00.3", Ports: []api.ResourcesNetworkCardPort{{ID: "enp13s0f0v1"}}},
+				{PCIAddress: "0000:0d:00.4", Driver: "vfio-pci"},
+				{PCIAddress: "0000:0d:00.5", Driver: "mlx5_core"},
+			},
+		},
+		Ports: []api.ResourcesNetworkCardPort{
+			{ID: "enp13s0f0", Address: "00:23:a4:01:01:6f", LinkDetected: true, LinkSpeed: 25000},
+			{ID: "enp13s0f1", Address: "00:23:a4:01:01:70"},
+		},
+	}, {
+		PCIAddress: "0000:0e:00.0",
+		Driver:     "igb",
+		Ports:      []api.ResourcesNetworkCardPort{{ID: "eth0"}},
+	}}
+
+	ports := instancePlacementMemberNetworkPorts(res)
+	require.Len(t, ports, 3)
+	assert.Equal(t, apiScriptlet.MemberNetworkPort{
+		ID:           "enp13s0f0",
+		Address:      "00:23:a4:01:01:6f",
+		PCIAddress:   "0000:0d:00.0",
+		Driver:       "mlx5_core",
+		NUMANode:     1,
+		LinkDetected: true,
+		LinkSpeed:    25000,
+		VFsCurrent:   4,
+		VFsMaximum:   64,
+		VFsFree:      62,
+	}, ports[0])
+	assert.Equal(t, uint64(64), ports[1].VFsMaximum)
+	assert.Equal(t, uint64(62), ports[1].VFsFree)
+	assert.Equal(t, "eth0", ports[2].ID)
+	assert.Equal(t, uint64(0), ports[2].VFsMaximum)
+	assert.Equal(t, uint64(0), ports[2].VFsFree)
+}
+
+func TestInstancePlacementMemberHugepages(t *testing.T) {
+	res := &api.Resources{}
+	assert.Empty(t, instancePlacementMemberHugepages(res))
+
+	res.Memory.HugepagesSize = 2 * 1024 * 1024
+	res.Memory.HugepagesTotal = 1024 * res.Memory.HugepagesSize
+	res.Memory.HugepagesUsed = 256 * res.Memory.HugepagesSize
+
+	assert.Equal(t, []apiScriptlet.MemberHugepages{{
+		PageSize: 2 * 1024 * 1024,
+		Total:    1024,
+		Free:     768,
+	}}, instancePlacementMemberHugepages(res))
+}
+
+func TestInstancePlacementClusterResources(t *testing.T) {
+	assert.Equal(t, apiScriptlet.ClusterResources{}, instancePlacementClusterResources(nil))
+
+	member1 := &api.Resources{}
+	member1.CPU.Total = 16
+	member1.Memory.Total = 32 * 1024 * 1024 * 1024
+	member1.Memory.Used = 8 * 1024 * 1024 * 1024
+	member1.Storage.Disks = []api.ResourcesStorageDisk{{Size: 500 * 1024 * 1024 * 1024}, {Size: 1000 * 1024 * 1024 * 1024}}
+
+	member2 := &api.Resources{}
+	member2.CPU.Total = 8
+	member2.Memory.Total = 16 * 1024 * 1024 * 1024
+	member2.Memory.Used = 12 * 1024 * 1024 * 1024
+	member2.Storage.Disks = []api.ResourcesStorageDisk{{Size: 250 * 1024 * 1024 * 1024}}
+
+	assert.Equal(t, apiScriptlet.ClusterResources{
+		Members:      2,
+		CPUTotal:     24,
+		MemoryTotal:  48 * 1024 * 1024 * 1024,
+		MemoryUsed:   20 * 1024 * 1024 * 1024,
+		StorageTotal: 1750 * 1024 * 1024 * 1024,
+	}, instancePlacementClusterResources([]*api.Resources{member1, member2}))
+}
+
+func TestInstancePlacementRun_LeastLoaded(t *testing.T) {
+	s, members := setupInstancePlacement(t, `
+def instance_placement(request, candidate_members):
+    if least_loaded("instances") != "none":
+        fail("Expected none to be the least loaded member")
+
+    if least_loaded("instances", label_key="tier", label_value="web") != "node2":
+        fail("Expected node2 to be the least loaded member for web instances")
+
+    if least_loaded("virtual-machine") != "node2":
+        fail("Expected tie to go to node2")
+
+    set_target(least_loaded("container", label_key="tier"))
+`)
+
+	createInstancePlacementInstance(t, s, api.ProjectDefaultName, "c2", "node2", map[string]string{"user.tier": "db"})
+	createInstancePlacementInstance(t, s, api.ProjectDefaultName, "c3", "node2", map[string]string{"user.tier": "db"})
+	createInstancePlacementInstance(t, s, api.ProjectDefaultName, "c4", "none", map[string]string{"user.tier": "web"})
+
+	placement, err := InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members, "")
+	require.NoError(t, err)
+	require.NotNil(t, placement.Member)
+	assert.Equal(t, "none", placement.Member.Name)
+
+	// Unknown dimensions are rejected.
+	err = scriptletLoad.InstancePlacementSet(`
+def instance_placement(request, candidate_members):
+    least_loaded("color")
+`)
+	require.NoError(t, err)
+
+	_, err = InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members, "")
+	assert.Error(t, err)
+}
+
+func TestInstancePlacementLoadBalance(t *testing.T) {
+	assert.Equal(t, apiScriptlet.ClusterLoadBalance{}, instancePlacementLoadBalance(nil, nil))
+
+	loads := map[string]float64{"node1": 4, "node2": 2, "node3": 0}
+	result := instancePlacementLoadBalance(loads, map[string]float64{"node1": 1, "node2": 1, "node3": 1})
+	assert.Equal(t, loads, result.Loads)
+	assert.InDelta(t, 2, result.Mean, 1e-9)
+	assert.InDelta(t, math.Sqrt(8.0/3.0), result.StdDev, 1e-9)
+	assert.Equal(t, "node3", result.Member)
+
+	// The member with the lowest load isn't the best choice when the added load weighs more on it.
+	result = instancePlacementLoadBalance(map[string]float64{"node1": 0.5, "node2": 0.4}, map[string]float64{"node1": 0.05, "node2": 0.5})
+	assert.InDelta(t, 0.45, result.Mean, 1e-9)
+	assert.InDelta(t, 0.05, result.StdDev, 1e-9)
+	assert.Equal(t, "node1", result.Member)
+
+	// Ties go to the lowest name.
+	result = instancePlacementLoadBalance(map[string]float64{"node2": 1, "node1": 1}, map[string]float64{"node1": 1, "node2": 1})
+	assert.Equal(t, "node1", result.Member)
+}
+
+func TestInstancePlacementRun_ClusterLoadBalance(t *testing.T) {
+	s, members := setupInstancePlacement(t, `
+def instance_placement(request, candidate_members):
+    balance = cluster_load_balance()
+    if balance["metric"] != "instances":
+        fail("Unexpected metric: {}".format(balance["metric"]))
+
+    if balance["loads"] != {"none": 3.0, "node2": 1.0}:
+        fail("Unexpected loads: {}".format(balance["loads"]))
+
+    if balance["mean"] != 2.0 or balance["stddev"] != 1.0:
+        fail("Unexpected statistics: {} {}".format(balance["mean"], balance["stddev"]))
+
+    set_target(balance["member"])
+`)
+
+	createInstancePlacementInstance(t, s, api.ProjectDefaultName, "c2", "none", nil)
+	createInstancePlacementInstance(t, s, api.ProjectDefaultName, "c3", "none", nil)
+	createInstancePlacementInstance(t, s, api.ProjectDefaultName, "c4", "none", nil)
+	createInstancePlacementInstance(t, s, api.ProjectDefaultName, "c5", "node2", nil)
+
+	placement, err := InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members, "")
+	require.NoError(t, err)
+	require.NotNil(t, placement.Member)
+	assert.Equal(t, "node2", placement.Member.Name)
+
+	// Unknown metrics are rejected.
+	err = scriptletLoad.InstancePlacementSet(`
+def instance_placement(request, candidate_members):
+    cluster_load_balance("disk")
+`)
+	require.NoError(t, err)
+
+	_, err = InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members, "")
+	assert.Error(t, err)
+}
+
+func TestInstancePlacementRun_ClusterLoadBalanceIgnoreRemoteErrors(t *testing.T) {
+	oldDelay := instancePlacementConnectRetryDelay
+	instancePlacementConnectRetryDelay = time.Millisecond
+	t.Cleanup(func() { instancePlacementConnectRetryDelay = oldDelay })
+
+	oldConnect := instancePlacementConnect
+	instancePlacementConnect = func(s *state.State, member db.NodeInfo) (incus.InstanceServer, error) {
+		return nil, fmt.Errorf("Member %q unreachable", member.Name)
+	}
+
+	t.Cleanup(func() { instancePlacementConnect = oldConnect })
+
+	s, members := setupInstancePlacement(t, `
+ignore_remote_errors = True
+
+def instance_placement(request, candidate_members):
+    balance = cluster_load_balance("cpu")
+    if balance == None or balance["loads"] != {}:
+        fail("Expected the unreachable member to be skipped: %s" % balance)
+`)
+
+	// Only keep the remote member so that the run doesn't depend on the local load.
+	remoteMembers := []db.NodeInfo{}
+	for _, member := range members {
+		if member.Name != s.ServerName {
+			remoteMembers = append(remoteMembers, member)
+		}
+	}
+
+	placement, err := InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), remoteMembers, "")
+	require.NoError(t, err)
+	require.Len(t, placement.RemoteErrors, 1)
+	assert.ErrorContains(t, placement.RemoteErrors[0], `Member "node2" unreachable`)
+}
+
+func TestInstancePlacementMemberSupportsMemoryHotplug(t *testing.T) {
+	newResources := func(arch string, flags ...string) *api.Resources {
+		res := &api.Resources{}
+		res.CPU.Architecture = arch
+		res.CPU.Sockets = []api.ResourcesCPUSocket{{Cores: []api.ResourcesCPUCore{{Flags: []string{"fpu", "sse2"}}, {Flags: flags}}}}
+
+		return res
+	}
+
+	assert.True(t, instancePlacementMemberSupportsMemoryHotplug(newResources("x86_64", "vmx")))
+	assert.True(t, instancePlacementMemberSupportsMemoryHotplug(newResources("x86_64", "svm")))
+	assert.False(t, instancePlacementMemberSupportsMemoryHotplug(newResources("x86_64")))
+	assert.True(t, instancePlacementMemberSupportsMemoryHotplug(newResources("aarch64")))
+	assert.False(t, instancePlacementMemberSupportsMemoryHotplug(newResources("s390x", "sie")))
+}
+
+func TestInstancePlacementRun_GetInstanceDeviceCounts(t *testing.T) {
+	s, members := setupInstancePlacement(t, `
+def instance_placement(request, candidate_members):
+    counts = get_instance_device_counts()
+    if counts != {"nic": 2, "disk": 3, "gpu": 1}:
+        fail("Unexpected device counts: %s" % counts)
+`)
+
+	req := newInstancePlacementRequest()
+	req.Devices = map[string]map[string]string{
+		"root": {"type": "disk", "path": "/", "pool": "default"},
+		"data": {"type": "disk", "path": "/data", "source": "vol1", "pool": "default"},
+		"iso":  {"type": "disk", "source": "/tmp/boot.iso"},
+		"eth0": {"type": "nic", "network": "incusbr0"},
+		"eth1": {"type": "nic", "nictype": "physical", "parent": "eth1"},
+		"gpu0": {"type": "gpu"},
+	}
+
+	_, err := InstancePlacementRun(context.Background(), logger.Log, s, req, members, "")
+	require.NoError(t, err)
+}
+
+func TestInstancePlacementNetworkRequirements(t *testing.T) {
+	reqs, err := instancePlacementNetworkRequirements(map[string]map[string]string{
+		"root": {"type": "disk", "path": "/", "pool": "default"},
+		"eth0": {"type": "nic", "network": "incusbr0", "limits.ingress": "1Gbit", "limits.egress": "100Mbit"},
+		"eth1": {"type": "nic", "network": "incusbr0", "limits.max": "10Mbit", "limits.ingress": "1Gbit"},
+		"eth2": {"type": "nic", "network": "incusbr0", "limits.egress": "50Mbit"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, &apiScriptlet.InstanceNetworkRequirements{
+		NICs:             3,
+		UnlimitedIngress: 1,
+		UnlimitedEgress:  0,
+		Ingress:          1000000000 + 10000000,
+		Egress:           100000000 + 10000000 + 50000000,
+	}, reqs)
+
+	_, err = instancePlacementNetworkRequirements(map[string]map[string]string{
+		"eth0": {"type": "nic", "limits.ingress": "fast"},
+	})
+	assert.Error(t, err)
+}
+
+func TestInstancePlacementRun_GetInstanceNetworkRequirements(t *testing.T) {
+	s, members := setupInstancePlacement(t, `
+def instance_placement(request, candidate_members):
+    reqs = get_instance_network_requirements()
+    if reqs["nics"] != 2 or reqs["ingress"] != 1000000000 or reqs["egress"] != 200000000:
+        fail("Unexpected network requirements: %s" % reqs)
+
+    if reqs["unlimited_ingress"] != 1 or reqs["unlimited_egress"] != 0:
+        fail("Unexpected unlimited NICs: %s" % reqs)
+`)
+
+	req := newInstancePlacementRequest()
+	req.Devices = map[string]map[string]string{
+		"root": {"type": "disk", "path": "/", "pool": "default"},
+		"eth0": {"type": "nic", "network": "incusbr0", "limits.ingress": "1Gbit", "limits.egress": "100Mbit"},
+		"eth1": {"type": "nic", "network": "incusbr0", "limits.egress": "100Mbit"},
+	}
+
+	_, err := InstancePlacementRun(context.Background(), logger.Log, s, req, members, "")
+	require.NoError(t, err)
+}
+
+func TestInstancePlacementRun_GetInstanceConfigSources(t *testing.T) {
+	s, members := setupInstancePlacement(t, `
+def instance_placement(request, candidate_members):
+    sources = get_instance_config_sources()
+    if sources["limits.cpu"] != {"source": "profile", "profile": "large"}:
+        fail("Unexpected source for limits.cpu: %s" % sources["limits.cpu"])
+
+    if sources["limits.memory"] != {"source": "instance", "profile": ""}:
+        fail("Unexpected source for limits.memory: %s" % sources["limits.memory"])
+
+    if sources["user.tier"] != {"source": "profile", "profile": "default"}:
+        fail("Unexpected source for user.tier: %s" % sources["user.tier"])
+
+    if "cloud-init.user-data" in sources or "cloud-init.user-data" in request.config_sources:
+        fail("Hidden key exposed")
+
+    if request.config_sources["limits.cpu"] != "large":
+        fail("Unexpected request config sources: %s" % request.config_sources)
+`)
+
+	err := s.DB.Cluster.Transaction(context.Background(), func(ctx context.Context, tx *db.ClusterTx) error {
+		var err error
+
+		s.GlobalConfig, err = clusterConfig.Load(ctx, tx)
+		if err != nil {
+			return err
+		}
+
+		_, err = s.GlobalConfig.Patch(map[string]string{"instances.placement.scriptlet.hidden_keys": "cloud-init.*"})
+		return err
+	})
+	require.NoError(t, err)
+
+	localConfig := map[string]string{"limits.memory": "4GiB", "cloud-init.user-data": "secret"}
+	profiles := []api.Profile{
+		{Name: "default", ProfilePut: api.ProfilePut{Config: map[string]string{"user.tier": "web", "limits.cpu": "2", "limits.memory": "1GiB"}}},
+		{Name: "large", ProfilePut: api.ProfilePut{Config: map[string]string{"limits.cpu": "8"}}},
+	}
+
+	req := newInstancePlacementRequest()
+	req.Config = db.ExpandInstanceConfig(localConfig, profiles)
+	req.ConfigSources = db.ExpandInstanceConfigSources(localConfig, profiles)
+
+	_, err = InstancePlacementRun(context.Background(), logger.Log, s, req, members, "")
+	require.NoError(t, err)
+}
+
+func TestInstancePlacementCanLiveMigrate(t *testing.T) {
+	newResources := func(arch string, flags ...[]string) *api.Resources {
+		res := &api.Resources{}
+		res.CPU.Architecture = arch
+
+		cores := make([]api.ResourcesCPUCore, 0, len(flags))
+		for _, coreFlags := range flags {
+			cores = append(cores, api.ResourcesCPUCore{Flags: coreFlags})
+		}
+
+		res.CPU.Sockets = []api.ResourcesCPUSocket{{Cores: cores}}
+
+		return res
+	}
+
+	source := newResources("x86_64", []string{"sse2", "avx", "avx2"}, []string{"sse2", "avx", "avx2", "avx512f"})
+
+	ok, reason := instancePlacementCanLiveMigrate(source, newResources("x86_64", []string{"sse2", "avx", "avx2", "aes"}))
+	assert.True(t, ok)
+	assert.Empty(t, reason)
+
+	// Flags only present on some of the source cores aren't required.
+	ok, reason = instancePlacementCanLiveMigrate(source, newResources("x86_64", []string{"sse2", "avx"}))
+	assert.False(t, ok)
+	assert.Equal(t, "Missing CPU flags: avx2", reason)
+
+	ok, reason = instancePlacementCanLiveMigrate(source, newResources("aarch64", []string{"fp", "asimd"}))
+	assert.False(t, ok)
+	assert.Equal(t, "Architecture mismatch: x86_64 != aarch64", reason)
+}
+
+func TestInstancePlacementRun_GetInstanceLocation(t *testing.T) {
+	s, members := setupInstancePlacement(t, `
+def instance_placement(request, candidate_members):
+    if get_instance_location("missing") != None:
+        fail("Unexpected location for missing instance")
+
+    set_target(get_instance_location("c2"))
+`)
+
+	createInstancePlacementInstance(t, s, api.ProjectDefaultName, "c2", "node2", nil)
+
+	placement, err := InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members, "")
+	require.NoError(t, err)
+	require.NotNil(t, placement.Member)
+	assert.Equal(t, "node2", placement.Member.Name)
+}
+
+func TestInstancePlacementRun_GetMemberInstances(t *testing.T) {
+	s, members := setupInstancePlacement(t, `
+def instance_placement(request, candidate_members):
+    names = [inst["name"] for inst in get_member_instances("node2")]
+    if names != ["c2", "c3"]:
+        fail("Unexpected instances on node2: {}".format(names))
+
+    running = get_member_instances("node2", running_only=True)
+    if len(running) != 1 or running[0]["name"] != "c2" or running[0]["project"] != "default" or running[0]["type"] != "container":
+        fail("Unexpected running instances on node2: {}".format(running))
+
+    if get_member_instances("missing") != []:
+        fail("Unexpected instances on missing member")
+
+    names = [inst["name"] for inst in get_member_instances("none", running_only=True)]
+    if names != ["c4"]:
+        fail("Unexpected running instances on none: {}".format(names))
+
+    set_target("none")
+`)
+
+	createInstancePlacementInstance(t, s, api.ProjectDefaultName, "c2", "node2", map[string]string{"volatile.last_state.power": "RUNNING"})
+	createInstancePlacementInstance(t, s, api.ProjectDefaultName, "c3", "node2", map[string]string{"volatile.last_state.power": "STOPPED"})
+	createInstancePlacementInstance(t, s, api.ProjectDefaultName, "c4", "none", map[string]string{"volatile.last_state.power": "RUNNING"})
+
+	placement, err := InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members, "")
+	require.NoError(t, err)
+	require.NotNil(t, placement.Member)
+	assert.Equal(t, "none", placement.Member.Name)
+}
+
+func TestInstancePlacementRun_InstanceExists(t *testing.T) {
+	s, members := setupInstancePlacement(t, `
+def instance_placement(request, candidate_members):
+    if not instance_exists("c2"):
+        fail("Expected c2 to exist")
+
+    if instance_exists("missing"):
+        fail("Unexpected missing instance")
+
+    if instance_exists("c2", project="other"):
+        fail("Unexpected c2 in other project")
+`)
+
+	createInstancePlacementInstance(t, s, api.ProjectDefaultName, "c2", "node2", nil)
+
+	_, err := InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members, "")
+	require.NoError(t, err)
+}
+
+func TestInstancePlacementChooseWeighted(t *testing.T) {
+	weights := map[string]float64{"node1": 1, "node2": 3, "node3": 0}
+
+	// The same seed yields the same choices.
+	rng1 := rand.New(rand.NewSource(42))
+	rng2 := rand.New(rand.NewSource(42))
+
+	counts := map[string]int{}
+	for i := 0; i < 1000; i++ {
+		name1, err := instancePlacementChooseWeighted(rng1, weights)
+		require.NoError(t, err)
+
+		name2, err := instancePlacementChooseWeighted(rng2, weights)
+		require.NoError(t, err)
+
+		assert.Equal(t, name1, name2)
+		counts[name1]++
+	}
+
+	assert.Zero(t, counts["node3"])
+	assert.Greater(t, counts["node2"], counts["node1"])
+
+	_, err := instancePlacementChooseWeighted(rng1, map[string]float64{"node1": 0})
+	assert.Error(t, err)
+}
+
+func TestInstancePlacementRun_ChooseWeighted(t *testing.T) {
+	oldSeed := instancePlacementSeed
+	instancePlacementSeed = func() int64 { return 42 }
+	t.Cleanup(func() { instancePlacementSeed = oldSeed })
+
+	s, members := setupInstancePlacement(t, `
+def instance_placement(request, candidate_members):
+    set_target(choose_weighted({"none": 0, "node2": 5}))
+`)
+
+	placement, err := InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members, "")
+	require.NoError(t, err)
+	require.NotNil(t, placement.Member)
+	assert.Equal(t, "node2", placement.Member.Name)
+
+	// Unknown members and negative weights are refused.
+	for _, weights := range []string{`{"foo": 1}`, `{"node2": -1}`} {
+		s, members = setupInstancePlacement(t, `
+def instance_placement(request, candidate_members):
+    choose_weighted(`+weights+`)
+`)
+
+		_, err = InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members, "")
+		assert.Error(t, err)
+	}
+}
+
+func TestInstancePlacementRendezvousHash(t *testing.T) {
+	names := []string{"node1", "node2", "node3", "node4"}
+
+	winners := map[string]string{}
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("instance%d", i)
+		winners[key] = instancePlacementRendezvousHash(key, names)
+	}
+
+	// Adding a member only moves the keys it wins.
+	added := append(slices.Clone(names), "node5")
+	for key, winner := range winners {
+		newWinner := instancePlacementRendezvousHash(key, added)
+		if newWinner != "node5" {
+			assert.Equal(t, winner, newWinner)
+		}
+	}
+
+	// Removing a member leaves the keys it wasn't winning in place.
+	removed := []string{"node1", "node2", "node3"}
+	for key, winner := range winners {
+		if winner != "node4" {
+			assert.Equal(t, winner, instancePlacementRendezvousHash(key, removed))
+		}
+	}
+
+	assert.Equal(t, "", instancePlacementRendezvousHash("instance0", nil))
+}
+
+func TestInstancePlacementRun_RendezvousHash(t *testing.T) {
+	s, members := setupInstancePlacement(t, `
+def instance_placement(request, candidate_members):
+    if rendezvous_hash(request.name) != rendezvous_hash(request.name, ["none", "node2"]):
+        fail("Default member names don't match the candidates")
+
+    set_target(rendezvous_hash(request.name))
+`)
+
+	placement, err := InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members, "")
+	require.NoError(t, err)
+	require.NotNil(t, placement.Member)
+	assert.Equal(t, instancePlacementRendezvousHash("c1", []string{"none", "node2"}), placement.Member.Name)
+}
+
+func TestInstancePlacementRun_GetRandom(t *testing.T) {
+	s, members := setupInstancePlacement(t, `
+def instance_placement(request, candidate_members):
+    first = [get_random(seed=42), get_random(), get_random()]
+    second = [get_random(seed=42), get_random(), get_random()]
+    if first != second:
+        fail("Same seed gave different sequences: %s != %s" % (first, second))
+
+    if first[0] == first[1] or first[0] < 0 or first[0] >= 1:
+        fail("Unexpected random numbers: %s" % first)
+
+    if get_random(seed=43) == first[0]:
+        fail("Different seeds gave the same number")
+`)
+
+	_, err := InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members, "")
+	require.NoError(t, err)
+
+	// Without a seed, runs are reproducible when the caller fixes the default seed.
+	oldSeed := instancePlacementSeed
+	instancePlacementSeed = func() int64 { return 42 }
+	t.Cleanup(func() { instancePlacementSeed = oldSeed })
+
+	require.NoError(t, scriptletLoad.InstancePlacementSet(`
+def instance_placement(request, candidate_members):
+    set_config_override("user.random", str(get_random()))
+`))
+
+	placement1, err := InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members, "")
+	require.NoError(t, err)
+
+	placement2, err := InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members, "")
+	require.NoError(t, err)
+
+	assert.NotEmpty(t, placement1.ConfigOverrides["user.random"])
+	assert.Equal(t, placement1.ConfigOverrides, placement2.ConfigOverrides)
+}
+
+func TestInstancePlacementRun_Now(t *testing.T) {
+	oldNow := instancePlacementNow
+	instancePlacementNow = func() time.Time {
+		return time.Date(2024, time.March, 5, 23, 30, 15, 0, time.FixedZone("UTC-2", -2*60*60))
+	}
+
+	t.Cleanup(func() { instancePlacementNow = oldNow })
+
+	s, members := setupInstancePlacement(t, `
+def instance_placement(request, candidate_members):
+    current = now()
+    if current.unix != 1709688615:
+        fail("Unexpected timestamp: %d" % current.unix)
+
+    if [current.year, current.month, current.day, current.hour, current.minute, current.second, current.weekday] != [2024, 3, 6, 1, 30, 15, 3]:
+        fail("Unexpected time: %s" % current)
+
+    # Avoid node2 during its maintenance window (01:00 to 02:00 UTC).
+    if current.hour == 1:
+        set_target("none")
+    else:
+        set_target("node2")
+`)
+
+	placement, err := InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members, "")
+	require.NoError(t, err)
+	require.NotNil(t, placement.Member)
+	assert.Equal(t, "none", placement.Member.Name)
+}
+
+func TestInstancePlacementBestScore(t *testing.T) {
+	assert.Equal(t, "", instancePlacementBestScore(nil))
+	assert.Equal(t, "node2", instancePlacementBestScore(map[string]float64{"node1": 1, "node2": 5, "node3": -2}))
+	assert.Equal(t, "node1", instancePlacementBestScore(map[string]float64{"node2": 3, "node1": 3}))
+}
+
+func TestInstancePlacementRun_Scores(t *testing.T) {
+	s, members := setupInstancePlacement(t, `
+def instance_placement(request, candidate_members):
+    return {"none": 1, "node2": 2.5, "foo": 10}
+`)
+
+	placement, err := InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members, "")
+	require.NoError(t, err)
+	require.NotNil(t, placement.Member)
+	assert.Equal(t, "node2", placement.Member.Name)
+
+	// No scored candidate falls back to the built-in placement.
+	s, members = setupInstancePlacement(t, `
+def instance_placement(request, candidate_members):
+    return {}
+`)
+
+	placement, err = InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members, "")
+	require.NoError(t, err)
+	assert.Nil(t, placement.Member)
+
+	// Scores must be numbers.
+	s, members = setupInstancePlacement(t, `
+def instance_placement(request, candidate_members):
+    return {"node2": "high"}
+`)
+
+	_, err = InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members, "")
+	assert.Error(t, err)
+
+	// Other return values are still refused.
+	s, members = setupInstancePlacement(t, `
+def instance_placement(request, candidate_members):
+    return "node2"
+`)
+
+	_, err = InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members, "")
+	assert.Error(t, err)
+}
+
+func TestInstancePlacementRun_Labels(t *testing.T) {
+	s, members := setupInstancePlacement(t, `
+def instance_placement(request, candidate_members):
+    if request.labels["rack"] != "r2":
+        fail("Unexpected labels: %s" % request.labels)
+
+    set_target("node2")
+`)
+
+	req := newInstancePlacementRequest()
+	req.Config["user.rack"] = "r2"
+	req.Config["limits.cpu"] = "2"
+
+	placement, err := InstancePlacementRun(context.Background(), logger.Log, s, req, members, "")
+	require.NoError(t, err)
+	require.NotNil(t, placement.Member)
+	assert.Equal(t, "node2", placement.Member.Name)
+	assert.Nil(t, req.Labels)
+
+	// Labels are always present.
+	s, members = setupInstancePlacement(t, `
+def instance_placement(request, candidate_members):
+    if len(request.labels) != 0:
+        fail("Unexpected labels: %s" % request.labels)
+`)
+
+	_, err = InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members, "")
+	require.NoError(t, err)
+}
+
+func TestInstancePlacementRun_BootPriority(t *testing.T) {
+	s, members := setupInstancePlacement(t, `
+def instance_placement(request, candidate_members):
+    if request.boot_priority != "10":
+        fail("Unexpected boot priority: %s" % request.boot_priority)
+`)
+
+	req := newInstancePlacementRequest()
+	req.Config["boot.autostart.priority"] = "10"
+
+	_, err := InstancePlacementRun(context.Background(), logger.Log, s, req, members, "")
+	require.NoError(t, err)
+	assert.Empty(t, req.BootPriority)
+
+	// The boot priority is empty when unset.
+	err = scriptletLoad.InstancePlacementSet(`
+def instance_placement(request, candidate_members):
+    if request.boot_priority != "":
+        fail("Unexpected boot priority: %s" % request.boot_priority)
+`)
+	require.NoError(t, err)
+
+	_, err = InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members, "")
+	require.NoError(t, err)
+}
+
+func TestInstancePlacementRun_Priority(t *testing.T) {
+	s, members := setupInstancePlacement(t, `
+def instance_placement(request, candidate_members):
+    if request.priority != "batch":
+        fail("Unexpected priority: %s" % request.priority)
+`)
+
+	req := newInstancePlacementRequest()
+	req.Config["user.priority"] = "batch"
+
+	_, err := InstancePlacementRun(context.Background(), logger.Log, s, req, members, "")
+	require.NoError(t, err)
+	assert.Empty(t, req.Priority)
+
+	// The priority is empty when unset or hidden.
+	err = scriptletLoad.InstancePlacementSet(`
+def instance_placement(request, candidate_members):
+    if request.priority != "":
+        fail("Unexpected priority: %s" % request.priority)
+`)
+	require.NoError(t, err)
+
+	_, err = InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members, "")
+	require.NoError(t, err)
+
+	err = s.DB.Cluster.Transaction(context.Background(), func(ctx context.Context, tx *db.ClusterTx) error {
+		var err error
+
+		s.GlobalConfig, err = clusterConfig.Load(ctx, tx)
+		if err != nil {
+			return err
+		}
+
+		_, err = s.GlobalConfig.Patch(map[string]string{"instances.placement.scriptlet.hidden_keys": "user.priority"})
+		return err
+	})
+	require.NoError(t, err)
+
+	_, err = InstancePlacementRun(context.Background(), logger.Log, s, req, members, "")
+	require.NoError(t, err)
+}
+
+func TestInstancePlacementRun_GetInstancesPending(t *testing.T) {
+	s, members := setupInstancePlacement(t, `
+def instance_placement(request, candidate_members):
+    if len(get_instances(location="node2")) != 1:
+        fail("Pending instance returned without being requested")
+
+    instances = get_instances(location="node2", pending=True)
+    if len(instances) != 2 or instances[1].status != "Pending":
+        fail("Pending instance missing: %s" % instances)
+`)
+
+	createInstancePlacementInstance(t, s, api.ProjectDefaultName, "c2", "node2", nil)
+
+	err := s.DB.Cluster.Transaction(context.Background(), func(ctx context.Context, tx *db.ClusterTx) error {
+		nodeID, err := dbCluster.GetNodeID(ctx, tx.Tx(), "node2")
+		if err != nil {
+			return err
+		}
+
+		projectID := int64(1)
+		_, err = dbCluster.CreateOrReplaceOperation(ctx, tx.Tx(), dbCluster.Operation{UUID: "op1", NodeID: nodeID, ProjectID: &projectID, Type: operationtype.InstanceCreate})
+		return err
+	})
+	require.NoError(t, err)
+
+	_, err = InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members, "")
+	require.NoError(t, err)
+}
+
+func TestInstancePlacementRun_GetMemberMaintenance(t *testing.T) {
+	s, members := setupInstancePlacement(t, `
+def instance_placement(request, candidate_members):
+    if get_member_maintenance("none") != "available":
+        fail("Member none should be available")
+
+    if get_member_maintenance("node2") != "evacuated":
+        fail("Member node2 should be evacuated")
+`)
+
+	err := s.DB.Cluster.Transaction(context.Background(), func(ctx context.Context, tx *db.ClusterTx) error {
+		member, err := tx.GetNodeByName(ctx, "node2")
+		if err != nil {
+			return err
+		}
+
+		return tx.UpdateNodeStatus(member.ID, db.ClusterMemberStateEvacuated)
+	})
+	require.NoError(t, err)
+
+	_, err = InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members, "")
+	require.NoError(t, err)
+
+	assert.Equal(t, "maintenance", instancePlacementMemberMaintenance(db.NodeInfo{Config: map[string]string{"scheduler.instance": "manual"}}))
+	assert.Equal(t, "maintenance", instancePlacementMemberMaintenance(db.NodeInfo{State: db.ClusterMemberStatePending}))
+}
+
+func TestInstancePlacementRun_Timeout(t *testing.T) {
+	s, members := setupInstancePlacement(t, `
+def instance_placement(request, candidate_members):
+    for i in range(1000000000):
+        pass
+`)
+
+	err := s.DB.Cluster.Transaction(context.Background(), func(ctx context.Context, tx *db.ClusterTx) error {
+		var err error
+
+		s.GlobalConfig, err = clusterConfig.Load(ctx, tx)
+		if err != nil {
+			return err
+		}
+
+		_, err = s.GlobalConfig.Patch(map[string]string{"instances.placement.scriptlet.timeout": "1"})
+		return err
+	})
+	require.NoError(t, err)
+
+	start := time.Now()
+	_, err = InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members, "")
+	assert.EqualError(t, err, "Timed out after 1s while running")
+	assert.Less(t, time.Since(start), 10*time.Second)
+}
+
+func TestInstancePlacementRun_SetTargetPool(t *testing.T) {
+	s, members := setupInstancePlacement(t, `
+def instance_placement(request, candidate_members):
+    set_target("none", pool="default")
+`)
+
+	err := s.DB.Cluster.Transaction(context.Background(), func(ctx context.Context, tx *db.ClusterTx) error {
+		poolID, err := tx.CreateStoragePool(ctx, "default", "", "dir", nil)
+		if err != nil {
+			return err
+		}
+
+		return tx.StoragePoolNodeCreated(poolID)
+	})
+	require.NoError(t, err)
+
+	placement, err := InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members, "")
+	require.NoError(t, err)
+	require.NotNil(t, placement.Member)
+	assert.Equal(t, "none", placement.Member.Name)
+	assert.Equal(t, "default", placement.Pool)
+
+	// The pool must exist on the selected member.
+	for _, src := range []string{`set_target("node2", pool="default")`, `set_target("none", pool="missing")`} {
+		err = scriptletLoad.InstancePlacementSet(`
+def instance_placement(request, candidate_members):
+    ` + src + `
+`)
+		require.NoError(t, err)
+
+		_, err = InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members, "")
+		assert.Error(t, err)
+	}
+
+	// The pool can't be changed when moving an existing instance.
+	err = scriptletLoad.InstancePlacementSet(`
+def instance_placement(request, candidate_members):
+    set_target("none", pool="default")
+`)
+	require.NoError(t, err)
+
+	for _, reason := range []string{apiScriptlet.InstancePlacementReasonRelocation, apiScriptlet.InstancePlacementReasonEvacuation} {
+		req := newInstancePlacementRequest()
+		req.Reason = reason
+
+		_, err = InstancePlacementRun(context.Background(), logger.Log, s, req, members, "")
+		assert.ErrorContains(t, err, "only supported when placing a new instance")
+	}
+}
+
+func TestInstancePlacementRun_SetTargets(t *testing.T) {
+	s, members := setupInstancePlacement(t, `
+def instance_placement(request, candidate_members):
+    set_targets([{"member": "node2"}, {"member": "none", "pool": "default"}])
+`)
+
+	err := s.DB.Cluster.Transaction(context.Background(), func(ctx context.Context, tx *db.ClusterTx) error {
+		poolID, err := tx.CreateStoragePool(ctx, "default", "", "dir", nil)
+		if err != nil {
+			return err
+		}
+
+		return tx.StoragePoolNodeCreated(poolID)
+	})
+	require.NoError(t, err)
+
+	placement, err := InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members, "")
+	require.NoError(t, err)
+	require.NotNil(t, placement.Member)
+	assert.Equal(t, "node2", placement.Member.Name)
+	assert.Empty(t, placement.Pool)
+
+	require.Len(t, placement.Targets, 2)
+	assert.Equal(t, "node2", placement.Targets[0].Member.Name)
+	assert.Empty(t, placement.Targets[0].Pool)
+	assert.Equal(t, "none", placement.Targets[1].Member.Name)
+	assert.Equal(t, "default", placement.Targets[1].Pool)
+
+	// A plain set_target yields a single target.
+	require.NoError(t, scriptletLoad.InstancePlacementSet(`
+def instance_placement(request, candidate_members):
+    set_targets([{"member": "node2"}, {"member": "none"}])
+    set_target("none")
+`))
+
+	placement, err = InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members, "")
+	require.NoError(t, err)
+	require.Len(t, placement.Targets, 1)
+	assert.Equal(t, "none", placement.Targets[0].Member.Name)
+
+	// Each target is validated.
+	for _, src := range []string{
+		`set_targets([])`,
+		`set_targets([{"member": "missing"}])`,
+		`set_targets([{"member": "none"}, {"member": "node2", "pool": "default"}])`,
+		`set_targets([{"pool": "default"}])`,
+		`set_targets([{"member": "none", "color": "red"}])`,
+		`set_targets(["none"])`,
+	} {
+		err = scriptletLoad.InstancePlacementSet(`
+def instance_placement(request, candidate_members):
+    ` + src + `
+`)
+		require.NoError(t, err)
+
+		_, err = InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members, "")
+		assert.Error(t, err, src)
+	}
+
+	// Fallbacks aren't supported when moving an existing instance.
+	require.NoError(t, scriptletLoad.InstancePlacementSet(`
+def instance_placement(request, candidate_members):
+    set_targets([{"member": "node2"}, {"member": "none"}])
+`))
+
+	for _, reason := range []string{apiScriptlet.InstancePlacementReasonRelocation, apiScriptlet.InstancePlacementReasonEvacuation} {
+		req := newInstancePlacementRequest()
+		req.Reason = reason
+
+		_, err = InstancePlacementRun(context.Background(), logger.Log, s, req, members, "")
+		assert.ErrorContains(t, err, "only supported when placing a new instance")
+	}
+}
+
+func TestInstancePlacementFits(t *testing.T) {
+	res := &api.Resources{}
+	res.CPU.Total = 8
+	res.Memory.Total = 16 * 1024 * 1024 * 1024
+	res.Memory.Used = 12 * 1024 * 1024 * 1024
+
+	pool := &api.ResourcesStoragePool{}
+	pool.Space.Total = 100 * 1024 * 1024 * 1024
+	pool.Space.Used = 90 * 1024 * 1024 * 1024
+
+	tests := []struct {
+		name  string
+		usage apiScriptlet.InstanceResources
+		pool  *api.ResourcesStoragePool
+		limit string
+	}{
+		{"fits", apiScriptlet.InstanceResources{CPUCores: 8, MemorySize: 4 * 1024 * 1024 * 1024, RootDiskSize: 10 * 1024 * 1024 * 1024}, pool, ""},
+		{"too many CPUs", apiScriptlet.InstanceResources{CPUCores: 16}, pool, "cpu"},
+		{"too much memory", apiScriptlet.InstanceResources{CPUCores: 2, MemorySize: 8 * 1024 * 1024 * 1024}, pool, "memory"},
+		{"too much disk", apiScriptlet.InstanceResources{CPUCores: 2, RootDiskSize: 20 * 1024 * 1024 * 1024}, pool, "disk"},
+		{"unknown pool", apiScriptlet.InstanceResources{CPUCores: 2, RootDiskSize: 20 * 1024 * 1024 * 1024}, nil, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.limit, instancePlacementFits(tt.usage, res, tt.pool))
+		})
+	}
+}
+
+func TestInstancePlacementRun_MemberSupportsArch(t *testing.T) {
+	s, members := setupInstancePlacement(t, `
+def instance_placement(request, candidate_members):
+    if member_supports_arch("node2"):
+        fail("Expected x86_64 member not to support aarch64")
+
+    if not member_supports_arch("none"):
+        fail("Expected aarch64 member to support aarch64")
+
+    if not member_supports_arch("node2", arch="i686"):
+        fail("Expected x86_64 member to support i686")
+
+    if member_supports_arch("missing") != "Invalid member name":
+        fail("Expected invalid member name")
+`)
+
+	for i := range members {
+		if members[i].Name == "node2" {
+			members[i].Architecture = osarch.ARCH_64BIT_INTEL_X86
+		} else {
+			members[i].Architecture = osarch.ARCH_64BIT_ARMV8_LITTLE_ENDIAN
+		}
+	}
+
+	req := newInstancePlacementRequest()
+	req.Architecture = "aarch64"
+
+	_, err := InstancePlacementRun(context.Background(), logger.Log, s, req, members, "")
+	require.NoError(t, err)
+}
+
+func TestInstancePlacementRun_GetClusterMembersOfflineSeconds(t *testing.T) {
+	s, members := setupInstancePlacement(t, `
+def instance_placement(request, candidate_members):
+    if len(get_cluster_members()) != 2:
+        fail("Expected all members with the global offline threshold")
+
+    members = get_cluster_members(offline_seconds=5)
+    if len(members) != 1 or members[0].server_name != "none":
+        fail("Expected node2 to be excluded: %s" % members)
+`)
+
+	err := s.DB.Cluster.Transaction(context.Background(), func(ctx context.Context, tx *db.ClusterTx) error {
+		err := tx.SetNodeHeartbeat("0.0.0.0", time.Now())
+		if err != nil {
+			return err
+		}
+
+		return tx.SetNodeHeartbeat("10.0.0.2:8443", time.Now().Add(-10*time.Second))
+	})
+	require.NoError(t, err)
+
+	_, err = InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members, "")
+	require.NoError(t, err)
+
+	// A looser override doesn't bring back members past the global threshold.
+	err = s.DB.Cluster.Transaction(context.Background(), func(ctx context.Context, tx *db.ClusterTx) error {
+		return tx.SetNodeHeartbeat("10.0.0.2:8443", time.Now().Add(-time.Hour))
+	})
+	require.NoError(t, err)
+
+	err = scriptletLoad.InstancePlacementSet(`
+def instance_placement(request, candidate_members):
+    members = get_cluster_members(offline_seconds=86400)
+    if len(members) != 1 or members[0].server_name != "none":
+        fail("Expected node2 to be excluded: %s" % members)
+`)
+	require.NoError(t, err)
+
+	_, err = InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members, "")
+	require.NoError(t, err)
+
+	// The override must be positive.
+	err = scriptletLoad.InstancePlacementSet(`
+def instance_placement(request, candidate_members):
+    get_cluster_members(offline_seconds=0)
+`)
+	require.NoError(t, err)
+
+	_, err = InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members, "")
+	assert.Error(t, err)
+}
+
+func TestInstancePlacementRun_GetStoragePoolDriver(t *testing.T) {
+	s, members := setupInstancePlacement(t, `
+def instance_placement(request, candidate_members):
+    driver = get_storage_pool_driver("none", "default")
+    if driver.name != "dir" or driver.remote:
+        fail("Unexpected storage pool driver: %s" % driver)
+`)
+
+	err := s.DB.Cluster.Transaction(context.Background(), func(ctx context.Context, tx *db.ClusterTx) error {
+		poolID, err := tx.CreateStoragePool(ctx, "default", "", "dir", nil)
+		if err != nil {
+			return err
+		}
+
+		return tx.StoragePoolNodeCreated(poolID)
+	})
+	require.NoError(t, err)
+
+	_, err = InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members, "")
+	require.NoError(t, err)
+}
+
+// instancePlacementTestServer is a remote cluster member serving fixed storage pool resources and server environment.
+type instancePlacementTestServer struct {
+	incus.InstanceServer
+
+	pools map[string]*api.ResourcesStoragePool
+	env   api.ServerEnvironment
+}
+
+func (r *instancePlacementTestServer) GetServer() (*api.Server, string, error) {
+	return &api.Server{Environment: r.env}, "", nil
+}
+
+func (r *instancePlacementTestServer) GetStoragePoolResources(poolName string) (*api.ResourcesStoragePool, error) {
+	pool, ok := r.pools[poolName]
+	if !ok {
+		return nil, api.StatusErrorf(http.StatusNotFound, "Storage pool not found")
+	}
+
+	return pool, nil
+}
+
+func TestInstancePlacementRun_MemberHasKernelFeature(t *testing.T) {
+	oldConnect := instancePlacementConnect
+	instancePlacementConnect = func(s *state.State, member db.NodeInfo) (incus.InstanceServer, error) {
+		return &instancePlacementTestServer{env: api.ServerEnvironment{KernelFeatures: map[string]string{"idmapped_mounts": "true", "seccomp_listener": "false"}}}, nil
+	}
+
+	t.Cleanup(func() { instancePlacementConnect = oldConnect })
+
+	s, members := setupInstancePlacement(t, `
+def instance_placement(request, candidate_members):
+    if not member_has_kernel_feature("node2", "idmapped_mounts"):
+        fail("Expected node2 to support idmapped mounts")
+
+    if member_has_kernel_feature("node2", "seccomp_listener"):
+        fail("Unexpected seccomp listener support on node2")
+
+    if member_has_kernel_feature("node2", "unpriv_fscaps"):
+        fail("Unexpected support for unreported feature on node2")
+
+    if not member_has_kernel_feature("none", "unpriv_binfmt"):
+        fail("Expected none to support unprivileged binfmt")
+
+    if member_has_kernel_feature("none", "idmapped_mounts"):
+        fail("Unexpected idmapped mounts support on none")
+
+    if member_has_kernel_feature("missing", "idmapped_mounts") != "Invalid member name":
+        fail("Expected invalid member name")
+`)
+
+	s.OS.UnprivBinfmt = true
+	s.OS.IdmappedMounts = false
+
+	_, err := InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members, "")
+	require.NoError(t, err)
+
+	// Unknown features are rejected rather than reported as missing.
+	err = scriptletLoad.InstancePlacementSet(`
+def instance_placement(request, candidate_members):
+    member_has_kernel_feature("node2", "vhost")
+`)
+	require.NoError(t, err)
+
+	_, err = InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members, "")
+	assert.ErrorContains(t, err, `Unknown kernel feature "vhost"`)
+}
+
+func TestInstancePlacementRun_PoolFreeSpace(t *testing.T) {
+	pool := &api.ResourcesStoragePool{}
+	pool.Space.Total = 100 * 1024 * 1024 * 1024
+	pool.Space.Used = 40 * 1024 * 1024 * 1024
+
+	oldConnect := instancePlacementConnect
+	instancePlacementConnect = func(s *state.State, member db.NodeInfo) (incus.InstanceServer, error) {
+		return &instancePlacementTestServer{pools: map[string]*api.ResourcesStoragePool{"data": pool}}, nil
+	}
+
+	t.Cleanup(func() { instancePlacementConnect = oldConnect })
+
+	s, members := setupInstancePlacement(t, `
+def instance_placement(request, candidate_members):
+    free = pool_free_space("node2", "data")
+    if free != 60 * 1024 * 1024 * 1024:
+        fail("Unexpected free space: %d" % free)
+
+    if pool_free_space("missing", "data") != "Invalid member name":
+        fail("Expected invalid member name")
+`)
+
+	_, err := InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members, "")
+	require.NoError(t, err)
+
+	// Unknown pools are refused.
+	require.NoError(t, scriptletLoad.InstancePlacementSet(`
+def instance_placement(request, candidate_members):
+    pool_free_space("node2", "missing")
+`))
+
+	_, err = InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members, "")
+	assert.ErrorContains(t, err, `Failed getting storage pool "missing" resources on member "node2"`)
+}
+
+func TestInstancePlacementRun_HiddenKeys(t *testing.T) {
+	s, members := setupInstancePlacement(t, `
+def instance_placement(request, candidate_members):
+    for key in ["cloud-init.user-data", "cloud-init.vendor-data", "user.secret"]:
+        if key in request.config:
+            fail("Hidden key %s exposed" % key)
+
+    if request.config["limits.cpu"] != "2" or request.labels["rack"] != "r1":
+        fail("Visible keys missing: %s" % request.config)
+
+    if "user.secret" in get_instances()[0].config:
+        fail("Hidden key exposed through get_instances")
+`)
+
+	err := s.DB.Cluster.Transaction(context.Background(), func(ctx context.Context, tx *db.ClusterTx) error {
+		var err error
+
+		s.GlobalConfig, err = clusterConfig.Load(ctx, tx)
+		if err != nil {
+			return err
+		}
+
+		_, err = s.GlobalConfig.Patch(map[string]string{"instances.placement.scriptlet.hidden_keys": "cloud-init.*,user.secret"})
+		return err
+	})
+	require.NoError(t, err)
+
+	createInstancePlacementInstance(t, s, api.ProjectDefaultName, "c2", "node2", map[string]string{"user.secret": "foo"})
+
+	req := newInstancePlacementRequest()
+	req.Config["cloud-init.user-data"] = "#cloud-config"
+	req.Config["cloud-init.vendor-data"] = "#cloud-config"
+	req.Config["user.secret"] = "foo"
+	req.Config["user.rack"] = "r1"
+	req.Config["limits.cpu"] = "2"
+
+	_, err = InstancePlacementRun(context.Background(), logger.Log, s, req, members, "")
+	require.NoError(t, err)
+
+	// The caller's request is left untouched.
+	assert.Equal(t, "foo", req.Config["user.secret"])
+}
+
+func TestInstancePlacementRun_GetClusterMemberRoles(t *testing.T) {
+	s, members := setupInstancePlacement(t, `
+def instance_placement(request, candidate_members):
+    roles = get_cluster_member_roles("node2")
+    if roles != ["ovn-chassis"]:
+        fail("Unexpected roles: %s" % roles)
+`)
+
+	err := s.DB.Cluster.Transaction(context.Background(), func(ctx context.Context, tx *db.ClusterTx) error {
+		member, err := tx.GetNodeByName(ctx, "node2")
+		if err != nil {
+			return err
+		}
+
+		return tx.UpdateNodeRoles(member.ID, []db.ClusterRole{db.ClusterRoleOVNChassis})
+	})
+	require.NoError(t, err)
+
+	_, err = InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members, "")
+	require.NoError(t, err)
+
+	member := db.NodeInfo{Address: "10.0.0.1:8443", Roles: []db.ClusterRole{db.ClusterRoleEventHub}}
+	raftNodes := []db.RaftNode{{NodeInfo: dqliteClient.NodeInfo{Address: "10.0.0.1:8443", Role: db.RaftVoter}}}
+	assert.Equal(t, []string{"event-hub", "database-leader", "database"}, instancePlacementMemberRoles(member, raftNodes, "10.0.0.1:8443"))
+}
+
+func TestInstancePlacementRun_IsLocalMember(t *testing.T) {
+	s, members := setupInstancePlacement(t, `
+def instance_placement(request, candidate_members):
+    local = [member.server_name for member in candidate_members if is_local_member(member.server_name)]
+    if local != ["none"]:
+        fail("Unexpected local members: %s" % local)
+
+    if is_local_member("missing"):
+        fail("Unexpected local missing member")
+
+    set_target(local[0])
+`)
+
+	placement, err := InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members, "")
+	require.NoError(t, err)
+	require.NotNil(t, placement.Member)
+	assert.Equal(t, s.ServerName, placement.Member.Name)
+}
+
+func TestInstancePlacementRun_MemberFailureDomain(t *testing.T) {
+	s, members := setupInstancePlacement(t, `
+def instance_placement(request, candidate_members):
+    if member_failure_domain("none") != "default":
+        fail("Unexpected failure domain for none: %s" % member_failure_domain("none"))
+
+    if member_failure_domain("node2") != "rack1":
+        fail("Unexpected failure domain for node2: %s" % member_failure_domain("node2"))
+`)
+
+	err := s.DB.Cluster.Transaction(context.Background(), func(ctx context.Context, tx *db.ClusterTx) error {
+		member, err := tx.GetNodeByName(ctx, "node2")
+		if err != nil {
+			return err
+		}
+
+		return tx.UpdateNodeFailureDomain(ctx, member.ID, "rack1")
+	})
+	require.NoError(t, err)
+
+	_, err = InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members, "")
+	require.NoError(t, err)
+
+	// Unknown members are reported.
+	err = scriptletLoad.InstancePlacementSet(`
+def instance_placement(request, candidate_members):
+    member_failure_domain("missing")
+`)
+	require.NoError(t, err)
+
+	_, err = InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members, "")
+	assert.Error(t, err)
+}
+
+func TestInstancePlacementDomainDensity(t *testing.T) {
+	members := []db.NodeInfo{
+		{Name: "node1", Address: "10.0.0.1:8443"},
+		{Name: "node2", Address: "10.0.0.2:8443"},
+		{Name: "node3", Address: "10.0.0.3:8443"},
+		{Name: "node4", Address: "10.0.0.4:8443"},
+	}
+
+	memberDomains := map[string]uint64{"10.0.0.1:8443": 1, "10.0.0.2:8443": 1, "10.0.0.3:8443": 2, "10.0.0.4:8443": 0}
+	domainNames := map[uint64]string{0: "default", 1: "rack1", 2: "rack2"}
+	counts := map[string]int{"node1": 3, "node2": 2, "node4": 1, "gone": 5}
+
+	assert.Equal(t, map[string]int{"rack1": 5, "rack2": 0, "default": 1}, instancePlacementDomainDensity(members, memberDomains, domainNames, counts))
+}
+
+func TestInstancePlacementRun_DomainDensity(t *testing.T) {
+	s, members := setupInstancePlacement(t, `
+def instance_placement(request, candidate_members):
+    density = domain_density()
+    if density != {"default": 1, "rack1": 2}:
+        fail("Unexpected density: %s" % density)
+`)
+
+	err := s.DB.Cluster.Transaction(context.Background(), func(ctx context.Context, tx *db.ClusterTx) error {
+		member, err := tx.GetNodeByName(ctx, "node2")
+		if err != nil {
+			return err
+		}
+
+		return tx.UpdateNodeFailureDomain(ctx, member.ID, "rack1")
+	})
+	require.NoError(t, err)
+
+	createInstancePlacementInstance(t, s, api.ProjectDefaultName, "c2", "node2", nil)
+	createInstancePlacementInstance(t, s, api.ProjectDefaultName, "c3", "node2", nil)
+	createInstancePlacementInstance(t, s, api.ProjectDefaultName, "c4", "none", nil)
+
+	_, err = InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members, "")
+	require.NoError(t, err)
+}
+
+func TestInstancePlacementRun_GetMemberAutostartFailures(t *testing.T) {
+	s, members := setupInstancePlacement(t, `
+def instance_placement(request, candidate_members):
+    failures = get_member_autostart_failures("node2")
+    if len(failures) != 1 or failures[0].instance != "c2" or failures[0].message != "Failed to start":
+        fail("Unexpected recent failures: %s" % failures)
+
+    if len(get_member_autostart_failures("node2", since=3*3600)) != 2:
+        fail("Expected older failure within the larger window")
+
+    if len(get_member_autostart_failures("none")) != 0:
+        fail("Unexpected failures on none")
+`)
+
+	createInstancePlacementInstance(t, s, api.ProjectDefaultName, "c2", "node2", nil)
+	createInstancePlacementInstance(t, s, api.ProjectDefaultName, "c3", "node2", nil)
+
+	err := s.DB.Cluster.Transaction(context.Background(), func(ctx context.Context, tx *db.ClusterTx) error {
+		for _, name := range []string{"c2", "c3"} {
+			id, err := dbCluster.GetInstanceID(ctx, tx.Tx(), api.ProjectDefaultName, name)
+			if err != nil {
+				return err
+			}
+
+			err = tx.UpsertWarning(ctx, "node2", api.ProjectDefaultName, dbCluster.TypeInstance, int(id), warningtype.InstanceAutostartFailure, "Failed to start")
+			if err != nil {
+				return err
+			}
+
+			if name == "c3" {
+				_, err = tx.Tx().Exec("UPDATE warnings SET last_seen_date = ? WHERE entity_id = ?", time.Now().UTC().Add(-2*time.Hour), id)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+	require.NoError(t, err)
+
+	_, err = InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members, "")
+	require.NoError(t, err)
+
+	// The window is bounded.
+	require.NoError(t, scriptletLoad.InstancePlacementSet(`
+def instance_placement(request, candidate_members):
+    get_member_autostart_failures("node2", since=2*86400)
+`))
+
+	_, err = InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members, "")
+	assert.Error(t, err)
+
+	// Unknown members are rejected.
+	require.NoError(t, scriptletLoad.InstancePlacementSet(`
+def instance_placement(request, candidate_members):
+    get_member_autostart_failures("missing")
+`))
+
+	_, err = InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members, "")
+	assert.ErrorContains(t, err, "Cluster member not found")
+}
+
+func TestInstancePlacementRun_GetMemberMetrics(t *testing.T) {
+	s, members := setupInstancePlacement(t, `
+def instance_placement(request, candidate_members):
+    samples = get_member_metrics("none", since=300)
+    if len(samples) != 1 or samples[0].load_average != 1.5:
+        fail("Unexpected samples: %s" % samples)
+`)
+
+	// Only the recent sample falls within the window.
+	metrics.LocalMemberHistory.Add(apiScriptlet.MemberMetricsSample{Timestamp: time.Now().Add(-time.Hour).Unix(), LoadAverage: 3})
+	metrics.LocalMemberHistory.Add(apiScriptlet.MemberMetricsSample{Timestamp: time.Now().Unix(), LoadAverage: 1.5})
+
+	_, err := InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members, "")
+	require.NoError(t, err)
+
+	// The window is bounded by the retained history.
+	err = scriptletLoad.InstancePlacementSet(`
+def instance_placement(request, candidate_members):
+    get_member_metrics("none", since=86400)
+`)
+	require.NoError(t, err)
+
+	_, err = InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members, "")
+	assert.Error(t, err)
+}
+
+func TestInstancePlacementRun_AreColocated(t *testing.T) {
+	s, members := setupInstancePlacement(t, `
+def instance_placement(request, candidate_members):
+    if are_colocated(["c2", "c3"]) != "node2":
+        fail("Expected c2 and c3 to be co-located")
+
+    if are_colocated(["c2", "c3", "c4"]) != None:
+        fail("Expected c2, c3 and c4 not to be co-located")
+`)
+
+	createInstancePlacementInstance(t, s, api.ProjectDefaultName, "c2", "node2", nil)
+	createInstancePlacementInstance(t, s, api.ProjectDefaultName, "c3", "node2", nil)
+	createInstancePlacementInstance(t, s, api.ProjectDefaultName, "c4", "none", nil)
+
+	_, err := InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members, "")
+	require.NoError(t, err)
+
+	// Missing instances are refused.
+	err = scriptletLoad.InstancePlacementSet(`
+def instance_placement(request, candidate_members):
+    are_colocated(["c2", "missing"])
+`)
+	require.NoError(t, err)
+
+	_, err = InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members, "")
+	assert.Error(t, err)
+}
+
+func TestInstancePlacementRun_GetClusterLimits(t *testing.T) {
+	s, members := setupInstancePlacement(t, `
+def instance_placement(request, candidate_members):
+    limits = get_cluster_limits()
+    if limits.offline_threshold != 30 or limits.healing_threshold != 60:
+        fail("Unexpected thresholds: %s" % limits)
+
+    if limits.rebalance_threshold != 10 or limits.vm_default_memory != "4GiB" or limits.vm_default_cpu != "":
+        fail("Unexpected limits: %s" % limits)
+`)
+
+	err := s.DB.Cluster.Transaction(context.Background(), func(ctx context.Context, tx *db.ClusterTx) error {
+		var err error
+
+		s.GlobalConfig, err = clusterConfig.Load(ctx, tx)
+		if err != nil {
+			return err
+		}
+
+		_, err = s.GlobalConfig.Patch(map[string]string{
+			"cluster.offline_threshold":                       "30",
+			"cluster.healing_threshold":                       "60",
+			"cluster.rebalance.threshold":                     "10",
+			"instances.placement.scriptlet.vm_default_memory": "4GiB",
+		})
+
+		return err
+	})
+	require.NoError(t, err)
+
+	_, err = InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members, "")
+	require.NoError(t, err)
+}
+
+func TestInstancePlacementRun_GetProjects(t *testing.T) {
+	s, members := setupInstancePlacement(t, `
+def instance_placement(request, candidate_members):
+    projects = get_projects()
+    if [p.name for p in projects] != ["default", "p1"]:
+        fail("Unexpected projects: %s" % projects)
+
+    if projects[1].config != {"limits.instances": "5"}:
+        fail("Unexpected project config: %s" % projects[1].config)
+`)
+
+	err := s.DB.Cluster.Transaction(context.Background(), func(ctx context.Context, tx *db.ClusterTx) error {
+		id, err := dbCluster.CreateProject(ctx, tx.Tx(), dbCluster.Project{Name: "p1"})
+		if err != nil {
+			return err
+		}
+
+		return dbCluster.CreateProjectConfig(ctx, tx.Tx(), id, map[string]string{"limits.instances": "5", "features.images": "true"})
+	})
+	require.NoError(t, err)
+
+	_, err = InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members, "")
+	require.NoError(t, err)
+}
+
+func TestInstancePlacementRun_GetProjectProfiles(t *testing.T) {
+	s, members := setupInstancePlacement(t, `
+def instance_placement(request, candidate_members):
+    names = [profile.name for profile in get_project_profiles()]
+    if names != ["default"]:
+        fail("Unexpected profiles for the request project: %s" % names)
+
+    profiles = get_project_profiles("p1")
+    names = [profile.name for profile in profiles]
+    if names != ["default", "gpu"]:
+        fail("Unexpected profiles for p1: %s" % names)
+
+    if profiles[1].config != {"limits.cpu": "2"}:
+        fail("Unexpected config for gpu profile: %s" % profiles[1].config)
+
+    profiles = get_project_profiles("p2")
+    if [(profile.project, profile.name) for profile in profiles] != [("default", "default")]:
+        fail("Expected p2 to use the default project profiles")
+`)
+
+	err := s.DB.Cluster.Transaction(context.Background(), func(ctx context.Context, tx *db.ClusterTx) error {
+		id, err := dbCluster.CreateProject(ctx, tx.Tx(), dbCluster.Project{Name: "p1"})
+		if err != nil {
+			return err
+		}
+
+		err = dbCluster.CreateProjectConfig(ctx, tx.Tx(), id, map[string]string{"features.profiles": "true"})
+		if err != nil {
+			return err
+		}
+
+		_, err = dbCluster.CreateProject(ctx, tx.Tx(), dbCluster.Project{Name: "p2"})
+		if err != nil {
+			return err
+		}
+
+		_, err = dbCluster.CreateProfile(ctx, tx.Tx(), dbCluster.Profile{Project: "p1", Name: "default"})
+		if err != nil {
+			return err
+		}
+
+		profileID, err := dbCluster.CreateProfile(ctx, tx.Tx(), dbCluster.Profile{Project: "p1", Name: "gpu"})
+		if err != nil {
+			return err
+		}
+
+		err = dbCluster.CreateProfileConfig(ctx, tx.Tx(), profileID, map[string]string{"limits.cpu": "2", "cloud-init.user-data": "secret"})
+		if err != nil {
+			return err
+		}
+
+		// Hidden keys are filtered out of the profiles too.
+		s.GlobalConfig, err = clusterConfig.Load(ctx, tx)
+		if err != nil {
+			return err
+		}
+
+		_, err = s.GlobalConfig.Patch(map[string]string{"instances.placement.scriptlet.hidden_keys": "cloud-init.*"})
+		return err
+	})
+	require.NoError(t, err)
+
+	_, err = InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members, "")
+	require.NoError(t, err)
+}
+
+func TestInstancePlacementRun_SkipSingleCandidate(t *testing.T) {
+	s, members := setupInstancePlacement(t, `
+def instance_placement(request, candidate_members):
+    fail("Scriptlet shouldn't be called")
+`)
+
+	// The scriptlet is called by default.
+	_, err := InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members[1:], "")
+	require.Error(t, err)
+
+	err = s.DB.Cluster.Transaction(context.Background(), func(ctx context.Context, tx *db.ClusterTx) error {
+		var err error
+
+		s.GlobalConfig, err = clusterConfig.Load(ctx, tx)
+		if err != nil {
+			return err
+		}
+
+		_, err = s.GlobalConfig.Patch(map[string]string{"instances.placement.scriptlet.skip_single_candidate": "true"})
+		return err
+	})
+	require.NoError(t, err)
+
+	var lifecycleEvents []api.EventLifecycle
+	s.Events = events.NewServer(false, false, func(event api.Event) {
+		if event.Type != api.EventTypeLifecycle {
+			return
+		}
+
+		lifecycleEvent := api.EventLifecycle{}
+		err := json.Unmarshal(event.Metadata, &lifecycleEvent)
+		if err != nil {
+			return
+		}
+
+		lifecycleEvents = append(lifecycleEvents, lifecycleEvent)
+	})
+
+	placement, err := InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members[1:], "")
+	require.NoError(t, err)
+	require.NotNil(t, placement.Member)
+	assert.Equal(t, members[1].Name, placement.Member.Name)
+
+	// The skipped run is still recorded.
+	require.Len(t, lifecycleEvents, 1)
+	assert.Equal(t, api.EventLifecycleInstancePlaced, lifecycleEvents[0].Action)
+	assert.Equal(t, members[1].Name, lifecycleEvents[0].Context["member"])
+	assert.Equal(t, true, lifecycleEvents[0].Context["skipped"])
+
+	// The shortcut doesn't query the database, so it works even with a cancelled context.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	placement, err = InstancePlacementRun(ctx, logger.Log, s, newInstancePlacementRequest(), members[1:], "")
+	require.NoError(t, err)
+	assert.Equal(t, members[1].Name, placement.Member.Name)
+
+	// The scriptlet still runs with several candidates.
+	_, err = InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members, "")
+	require.Error(t, err)
+
+	// Scriptlets marked as always_run are still called.
+	require.NoError(t, scriptletLoad.InstancePlacementSet(`
+always_run = True
+
+def instance_placement(request, candidate_members):
+    fail("Scriptlet called")
+`))
+
+	_, err = InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members[1:], "")
+	require.Error(t, err)
+}
+
+func TestInstancePlacementRun_GetInstanceSnapshots(t *testing.T) {
+	s, members := setupInstancePlacement(t, `
+def instance_placement(request, candidate_members):
+    snapshots = get_instance_snapshots("c2")
+    if [(snapshot.name, snapshot.stateful) for snapshot in snapshots] != [("snap0", False), ("snap1", True)]:
+        fail("Unexpected snapshots: %s" % snapshots)
+
+    if snapshots[0].created_at != 1700000000 or snapshots[0].expires_at != 0:
+        fail("Unexpected dates for snap0")
+
+    if snapshots[1].expires_at != 1700086400:
+        fail("Unexpected expiry for snap1")
+
+    if get_instance_snapshots("c3", project="default") != []:
+        fail("Expected no snapshots for c3")
+`)
+
+	createInstancePlacementInstance(t, s, api.ProjectDefaultName, "c2", "node2", nil)
+	createInstancePlacementInstance(t, s, api.ProjectDefaultName, "c3", "node2", nil)
+
+	err := s.DB.Cluster.Transaction(context.Background(), func(ctx context.Context, tx *db.ClusterTx) error {
+		// Created out of order to check the sorting.
+		_, err := dbCluster.CreateInstanceSnapshot(ctx, tx.Tx(), dbCluster.InstanceSnapshot{
+			Project:      api.ProjectDefaultName,
+			Instance:     "c2",
+			Name:         "snap1",
+			CreationDate: time.Unix(1700003600, 0),
+			Stateful:     true,
+			ExpiryDate:   sql.NullTime{Time: time.Unix(1700086400, 0), Valid: true},
+		})
+		if err != nil {
+			return err
+		}
+
+		_, err = dbCluster.CreateInstanceSnapshot(ctx, tx.Tx(), dbCluster.InstanceSnapshot{
+			Project:      api.ProjectDefaultName,
+			Instance:     "c2",
+			Name:         "snap0",
+			CreationDate: time.Unix(1700000000, 0),
+		})
+
+		return err
+	})
+	require.NoError(t, err)
+
+	_, err = InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members, "")
+	require.NoError(t, err)
+
+	// Missing instances are refused.
+	require.NoError(t, scriptletLoad.InstancePlacementSet(`
+def instance_placement(request, candidate_members):
+    get_instance_snapshots("missing")
+`))
+
+	_, err = InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members, "")
+	assert.Error(t, err)
+}
+
+func TestInstancePlacementRun_ConnectRetry(t *testing.T) {
+	oldDelay := instancePlacementConnectRetryDelay
+	instancePlacementConnectRetryDelay = time.Millisecond
+	t.Cleanup(func() { instancePlacementConnectRetryDelay = oldDelay })
+
+	pool := &api.ResourcesStoragePool{}
+	pool.Space.Total = 100 * 1024 * 1024 * 1024
+
+	attempts := 0
+	oldConnect := instancePlacementConnect
+	instancePlacementConnect = func(s *state.State, member db.NodeInfo) (incus.InstanceServer, error) {
+		attempts++
+		if attempts == 1 {
+			return nil, fmt.Errorf("Member %q unreachable", member.Name)
+		}
+
+		return &instancePlacementTestServer{pools: map[string]*api.ResourcesStoragePool{"data": pool}}, nil
+	}
+
+	t.Cleanup(func() { instancePlacementConnect = oldConnect })
+
+	s, members := setupInstancePlacement(t, `
+def instance_placement(request, candidate_members):
+    if pool_free_space("node2", "data") != 100 * 1024 * 1024 * 1024:
+        fail("Unexpected free space")
+
+    set_target("node2")
+`)
+
+	placement, err := InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members, "")
+	require.NoError(t, err)
+	require.NotNil(t, placement.Member)
+	assert.Equal(t, "node2", placement.Member.Name)
+	assert.Equal(t, 2, attempts)
+
+	// Without retries the first failure is reported.
+	err = s.DB.Cluster.Transaction(context.Background(), func(ctx context.Context, tx *db.ClusterTx) error {
+		var err error
+
+		s.GlobalConfig, err = clusterConfig.Load(ctx, tx)
+		if err != nil {
+			return err
+		}
+
+		_, err = s.GlobalConfig.Patch(map[string]string{"instances.placement.scriptlet.connect_retries": "0"})
+		return err
+	})
+	require.NoError(t, err)
+
+	attempts = 0
+	_, err = InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members, "")
+	assert.ErrorContains(t, err, "unreachable")
+	assert.Equal(t, 1, attempts)
+}
+
+func TestInstancePlacementConnectWithRetry_Cancel(t *testing.T) {
+	attempts := 0
+	oldConnect := instancePlacementConnect
+	instancePlacementConnect = func(s *state.State, member db.NodeInfo) (incus.InstanceServer, error) {
+		attempts++
+		return nil, fmt.Errorf("Member %q unreachable", member.Name)
+	}
+
+	t.Cleanup(func() { instancePlacementConnect = oldConnect })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := instancePlacementConnectWithRetry(ctx, nil, db.NodeInfo{Name: "node2"}, 10)
+	assert.ErrorContains(t, err, "unreachable")
+	assert.Equal(t, 1, attempts)
+}
+
+func TestInstancePlacementRun_IgnoreRemoteErrors(t *testing.T) {
+	oldConnect := instancePlacementConnect
+	instancePlacementConnect = func(s *state.State, member db.NodeInfo) (incus.InstanceServer, error) {
+		return nil, fmt.Errorf("Member %q unreachable", member.Name)
+	}
+
+	t.Cleanup(func() { instancePlacementConnect = oldConnect })
+
+	s, members := setupInstancePlacement(t, `
+ignore_remote_errors = True
+
+def instance_placement(request, candidate_members):
+    if get_cluster_member_resources("node2") == None:
+        set_target("none")
+        return
+
+    set_target("node2")
+`)
+
+	placement, err := InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members, "")
+	require.NoError(t, err)
+	require.NotNil(t, placement.Member)
+	assert.Equal(t, "none", placement.Member.Name)
+	require.Len(t, placement.RemoteErrors, 1)
+	assert.ErrorContains(t, placement.RemoteErrors[0], `Member "node2" unreachable`)
+
+	// Without the flag, the remote failure aborts the run.
+	require.NoError(t, scriptletLoad.InstancePlacementSet(`
+def instance_placement(request, candidate_members):
+    get_cluster_member_resources("node2")
+`))
+
+	_, err = InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members, "")
+	assert.Error(t, err)
+}
+
+func TestInstancePlacementRun_GetOVNChassis(t *testing.T) {
+	s, members := setupInstancePlacement(t, `
+def instance_placement(request, candidate_members):
+    chassis = get_ovn_chassis()
+    if chassis != ["node2"]:
+        fail("Unexpected OVN chassis: %s" % chassis)
+`)
+
+	err := s.DB.Cluster.Transaction(context.Background(), func(ctx context.Context, tx *db.ClusterTx) error {
+		member, err := tx.GetNodeByName(ctx, "node2")
+		if err != nil {
+			return err
+		}
+
+		return tx.UpdateNodeRoles(member.ID, []db.ClusterRole{db.ClusterRoleOVNChassis})
+	})
+	require.NoError(t, err)
+
+	_, err = InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members, "")
+	require.NoError(t, err)
+
+	// Without any chassis role, all members act as chassis.
+	assert.Equal(t, []string{"none", "node2"}, instancePlacementOVNChassis([]db.NodeInfo{{Name: "none"}, {Name: "node2"}}))
+}
+
+func TestInstancePlacementRun_GetInstancesCountAllProjects(t *testing.T) {
+	s, members := setupInstancePlacement(t, `
+def instance_placement(request, candidate_members):
+    total = get_instances_count(project="default", all_projects=True)
+    if total != get_instances_count(project="default") + get_instances_count(project="p1"):
+        fail("Unexpected count across all projects: %d" % total)
+
+    if total != 3:
+        fail("Expected 3 instances, got %d" % total)
+
+    if get_instances_count(project="p1", location="node2", all_projects=True) != 2:
+        fail("Unexpected count across all projects on node2")
+`)
+
+	err := s.DB.Cluster.Transaction(context.Background(), func(ctx context.Context, tx *db.ClusterTx) error {
+		_, err := dbCluster.CreateProject(ctx, tx.Tx(), dbCluster.Project{Name: "p1"})
+		return err
+	})
+	require.NoError(t, err)
+
+	createInstancePlacementInstance(t, s, api.ProjectDefaultName, "c2", "node2", nil)
+	createInstancePlacementInstance(t, s, "p1", "c3", "node2", nil)
+	createInstancePlacementInstance(t, s, "p1", "c4", "none", nil)
+
+	_, err = InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members, "")
+	require.NoError(t, err)
+}
+
+func TestInstancePlacementRun_GetInstanceResourcesRootDiskPool(t *testing.T) {
+	s, members := setupInstancePlacement(t, `
+def instance_placement(request, candidate_members):
+    res = get_instance_resources()
+    if res.root_disk_pool != request.config.get("user.expected_pool", ""):
+        fail("Unexpected root disk pool: %s" % res.root_disk_pool)
+`)
+
+	// No root disk.
+	_, err := InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members, "")
+	require.NoError(t, err)
+
+	req := newInstancePlacementRequest()
+	req.Config["user.expected_pool"] = "fast"
+	req.Devices["root"] = map[string]string{"type": "disk", "path": "/", "pool": "fast"}
+
+	_, err = InstancePlacementRun(context.Background(), logger.Log, s, req, members, "")
+	require.NoError(t, err)
+}
+
+func TestInstancePlacementRun_GetInstanceResourcesVMDefaults(t *testing.T) {
+	s, members := setupInstancePlacement(t, `
+def instance_placement(request, candidate_members):
+    res = get_instance_resources()
+    expected = request.config["user.expected"].split(",")
+    if [str(res.cpu_cores), str(res.memory_size), str(res.root_disk_size)] != expected:
+        fail("Unexpected resources: %s" % res)
+`)
+
+	req := newInstancePlacementRequest()
+	req.Type = api.InstanceTypeVM
+	req.Devices["root"] = map[string]string{"type": "disk", "path": "/", "pool": "default"}
+
+	// Built-in defaults.
+	req.Config["user.expected"] = "1,1073741824,10737418240"
+	_, err := InstancePlacementRun(context.Background(), logger.Log, s, req, members, "")
+	require.NoError(t, err)
+
+	err = s.DB.Cluster.Transaction(context.Background(), func(ctx context.Context, tx *db.ClusterTx) error {
+		var err error
+
+		s.GlobalConfig, err = clusterConfig.Load(ctx, tx)
+		if err != nil {
+			return err
+		}
+
+		_, err = s.GlobalConfig.Patch(map[string]string{
+			"instances.placement.scriptlet.vm_default_cpu":            "4",
+			"instances.placement.scriptlet.vm_default_memory":         "8GiB",
+			"instances.placement.scriptlet.vm_default_root_disk_size": "50GiB",
+		})
+
+		return err
+	})
+	require.NoError(t, err)
+
+	// Overridden defaults.
+	req.Config["user.expected"] = "4,8589934592,53687091200"
+	_, err = InstancePlacementRun(context.Background(), logger.Log, s, req, members, "")
+	require.NoError(t, err)
+
+	// Explicit limits still win.
+	req.Config["limits.cpu"] = "2"
+	req.Config["user.expected"] = "2,8589934592,53687091200"
+	_, err = InstancePlacementRun(context.Background(), logger.Log, s, req, members, "")
+	require.NoError(t, err)
+
+	// The request itself is left untouched.
+	assert.Empty(t, req.Devices["root"]["size"])
+}
+
+func TestInstancePlacementRun_GetInstanceVolumes(t *testing.T) {
+	s, members := setupInstancePlacement(t, `
+def instance_placement(request, candidate_members):
+    volumes = get_instance_volumes("c2")
+    if [(v.device, v.name, v.pool, v.project, v.location) for v in volumes] != [("data", "vol1", "default", "default", "none")]:
+        fail("Unexpected volumes: %s" % volumes)
+
+    if get_instance_volumes("c3", project="default") != []:
+        fail("Expected no volumes for c3")
+`)
+
+	createInstancePlacementInstance(t, s, api.ProjectDefaultName, "c2", "none", nil)
+	createInstancePlacementInstance(t, s, api.ProjectDefaultName, "c3", "none", nil)
+
+	err := s.DB.Cluster.Transaction(context.Background(), func(ctx context.Context, tx *db.ClusterTx) error {
+		poolID, err := tx.CreateStoragePool(ctx, "default", "", "dir", nil)
+		if err != nil {
+			return err
+		}
+
+		for _, name := range []string{"vol1", "vol2"} {
+			_, err = tx.CreateStoragePoolVolume(ctx, api.ProjectDefaultName, name, "", db.StoragePoolVolumeTypeCustom, poolID, nil, db.StoragePoolVolumeContentTypeFS, time.Now())
+			if err != nil {
+				return err
+			}
+		}
+
+		instance, err := dbCluster.GetInstance(ctx, tx.Tx(), api.ProjectDefaultName, "c2")
+		if err != nil {
+			return err
+		}
+
+		return dbCluster.CreateInstanceDevices(ctx, tx.Tx(), int64(instance.ID), map[string]dbCluster.Device{
+			"root": {Name: "root", Type: dbCluster.TypeDisk, Config: map[string]string{"path": "/", "pool": "default"}},
+			"data": {Name: "data", Type: dbCluster.TypeDisk, Config: map[string]string{"path": "/mnt", "pool": "default", "source": "vol1"}},
+			"host": {Name: "host", Type: dbCluster.TypeDisk, Config: map[string]string{"path": "/srv", "source": "/srv"}},
+		})
+	})
+	require.NoError(t, err)
+
+	_, err = InstancePlacementRun(context.Background(), logger.Log, s, newInstancePlacementRequest(), members, "")
+	require.NoError(t, err)
+}
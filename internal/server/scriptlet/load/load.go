@@ -18,6 +18,9 @@ const nameAuthorization = "authorization"
 var programsMu sync.Mutex
 var programs = make(map[string]*starlark.Program)
 
+// Whether the instance placement scriptlet sets a global always_run, evaluated when it's set.
+var instancePlacementAlwaysRun bool
+
 // InstancePlacementCompile compiles the instance placement scriptlet.
 func InstancePlacementCompile(name string, src string) (*starlark.Program, error) {
 	return compile(name, src, []string{
@@ -25,13 +28,54 @@ func InstancePlacementCompile(name string, src string) (*starlark.Program, error
 		"log_warn",
 		"log_error",
 		"set_target",
+		"set_targets",
+		"reject_placement",
+		"request_retry",
+		"set_config_override",
+		"choose_weighted",
+		"rendezvous_hash",
+		"get_random",
+		"now",
 		"get_cluster_member_resources",
+		"get_cluster_resources",
+		"get_cluster_limits",
 		"get_cluster_member_state",
+		"get_member_gpus",
+		"get_member_hugepages",
+		"get_member_network_ports",
+		"get_member_maintenance",
+		"get_cluster_member_roles",
+		"is_local_member",
+		"member_failure_domain",
+		"domain_density",
+		"get_ovn_chassis",
+		"member_fits",
+		"can_live_migrate",
+		"member_supports_arch",
+		"member_supports_memory_hotplug",
+		"member_has_kernel_feature",
+		"get_member_metrics",
+		"get_member_autostart_failures",
+		"get_storage_pool_driver",
+		"pool_free_space",
 		"get_instance_resources",
+		"get_instance_device_counts",
+		"get_instance_network_requirements",
+		"get_instance_config_sources",
+		"get_instance_snapshots",
+		"get_instance_volumes",
 		"get_instances",
 		"get_instances_count",
+		"least_loaded",
+		"cluster_load_balance",
+		"get_instance_location",
+		"get_member_instances",
+		"instance_exists",
+		"are_colocated",
 		"get_cluster_members",
 		"get_project",
+		"get_projects",
+		"get_project_profiles",
 	})
 }
 
@@ -45,7 +89,29 @@ func InstancePlacementValidate(src string) error {
 // InstancePlacementSet compiles the instance placement scriptlet into memory for use with InstancePlacementRun.
 // If empty src is provided the current program is deleted.
 func InstancePlacementSet(src string) error {
-	return set(InstancePlacementCompile, nameInstancePlacement, src)
+	err := set(InstancePlacementCompile, nameInstancePlacement, src)
+	if err != nil {
+		return err
+	}
+
+	alwaysRun := false
+	if src != "" {
+		prog, thread, err := InstancePlacementProgram()
+		if err != nil {
+			return err
+		}
+
+		// Evaluate the globals without any builtins, like validation does.
+		// If that fails, there's no telling whether the scriptlet wants to always run so assume it does.
+		globals, err := prog.Init(thread, nil)
+		alwaysRun = err != nil || (globals["always_run"] != nil && bool(globals["always_run"].Truth()))
+	}
+
+	programsMu.Lock()
+	instancePlacementAlwaysRun = alwaysRun
+	programsMu.Unlock()
+
+	return nil
 }
 
 // InstancePlacementProgram returns the precompiled instance placement scriptlet program.
@@ -53,6 +119,14 @@ func InstancePlacementProgram() (*starlark.Program, *starlark.Thread, error) {
 	return program("Instance placement", nameInstancePlacement)
 }
 
+// InstancePlacementAlwaysRun returns whether the instance placement scriptlet sets a global always_run.
+func InstancePlacementAlwaysRun() bool {
+	programsMu.Lock()
+	defer programsMu.Unlock()
+
+	return instancePlacementAlwaysRun
+}
+
 // QEMUCompile compiles the QEMU scriptlet.
 func QEMUCompile(name string, src string) (*starlark.Program, error) {
 	return compile(name, src, []string{
@@ -240,6 +240,19 @@ func (s *OS) GetUnixSocket() string {
 	return filepath.Join(s.VarDir, "unix.socket")
 }
 
+// KernelFeatures returns the optional kernel features detected at startup, as reported in the server environment.
+func (s *OS) KernelFeatures() map[string]string {
+	return map[string]string{
+		"netnsid_getifaddrs":        strconv.FormatBool(s.NetnsGetifaddrs),
+		"uevent_injection":          strconv.FormatBool(s.UeventInjection),
+		"unpriv_binfmt":             strconv.FormatBool(s.UnprivBinfmt),
+		"unpriv_fscaps":             strconv.FormatBool(s.VFS3Fscaps),
+		"seccomp_listener":          strconv.FormatBool(s.SeccompListener),
+		"seccomp_listener_continue": strconv.FormatBool(s.SeccompListenerContinue),
+		"idmapped_mounts":           strconv.FormatBool(s.IdmappedMounts),
+	}
+}
+
 func getIdmapset() *idmap.Set {
 	// Try getting the system map.
 	idmapset, err := idmap.NewSetFromSystem("", "root")
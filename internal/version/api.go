@@ -461,6 +461,66 @@ var APIExtensions = []string{
 	"instance_debug_memory",
 	"init_preseed_storage_volumes",
 	"init_preseed_profile_project",
+	"instances_scriptlet_reject_placement",
+	"instances_scriptlet_config_override",
+	"instances_scriptlet_get_instances_count_group_by",
+	"instances_scriptlet_get_member_gpus",
+	"instances_scriptlet_get_instance_location",
+	"instances_scriptlet_choose_weighted",
+	"instances_scriptlet_scores",
+	"instances_scriptlet_labels",
+	"instances_scriptlet_get_instances_pending",
+	"instances_scriptlet_get_member_maintenance",
+	"instances_scriptlet_set_target_pool",
+	"instances_scriptlet_member_fits",
+	"instances_scriptlet_get_cluster_members_offline_seconds",
+	"instances_scriptlet_get_storage_pool_driver",
+	"instances_scriptlet_hidden_keys",
+	"instances_scriptlet_get_cluster_member_roles",
+	"instances_scriptlet_get_member_metrics",
+	"instances_scriptlet_are_colocated",
+	"instances_scriptlet_placement_event",
+	"instances_scriptlet_rendezvous_hash",
+	"instances_scriptlet_get_project_profiles",
+	"instances_scriptlet_skip_single_candidate",
+	"instances_scriptlet_get_instance_snapshots",
+	"instances_scriptlet_ignore_remote_errors",
+	"instances_scriptlet_get_ovn_chassis",
+	"instances_scriptlet_get_member_hugepages",
+	"instances_scriptlet_get_member_network_ports",
+	"instances_scriptlet_get_instances_count_all_projects",
+	"instances_scriptlet_get_random",
+	"instances_scriptlet_instance_resources_root_disk_pool",
+	"instances_scriptlet_vm_defaults",
+	"instances_scriptlet_get_instance_volumes",
+	"instances_scriptlet_get_cluster_resources",
+	"instances_scriptlet_member_supports_arch",
+	"instances_scriptlet_boot_priority",
+	"instances_scriptlet_least_loaded",
+	"instances_scriptlet_pool_free_space",
+	"instances_scriptlet_instance_exists",
+	"instances_scriptlet_timeout",
+	"instances_scriptlet_get_projects",
+	"instances_scriptlet_get_member_autostart_failures",
+	"instances_scriptlet_set_targets",
+	"instances_scriptlet_member_supports_memory_hotplug",
+	"instances_scriptlet_get_instance_device_counts",
+	"instances_scriptlet_get_cluster_limits",
+	"instances_scriptlet_can_live_migrate",
+	"instances_scriptlet_cluster_load_balance",
+	"instances_scriptlet_get_member_instances",
+	"instances_scriptlet_connect_retries",
+	"instances_scriptlet_get_instance_network_requirements",
+	"instances_scriptlet_get_instance_config_sources",
+	"instances_scriptlet_is_local_member",
+	"instances_scriptlet_member_failure_domain",
+	"instances_scriptlet_priority",
+	"instances_scriptlet_domain_density",
+	"instances_scriptlet_request_retry",
+	"instances_scriptlet_now",
+	"instances_scriptlet_member_has_kernel_feature",
+	"cluster_member_ovn_chassis_event",
+	"metrics_ovn_chassis",
 }
 
 // APIExtensionsCount returns the number of available API extensions.
@@ -15,6 +15,7 @@ const (
 	EventLifecycleClusterMemberAdded                = "cluster-member-added"
 	EventLifecycleClusterMemberEvacuated            = "cluster-member-evacuated"
 	EventLifecycleClusterMemberHealed               = "cluster-member-healed"
+	EventLifecycleClusterMemberOVNChassisUpdated    = "cluster-member-ovn-chassis-updated"
 	EventLifecycleClusterMemberRemoved              = "cluster-member-removed"
 	EventLifecycleClusterMemberRenamed              = "cluster-member-renamed"
 	EventLifecycleClusterMemberRestored             = "cluster-member-restored"
@@ -53,6 +54,7 @@ const (
 	EventLifecycleInstanceMetadataUpdated           = "instance-metadata-updated"
 	EventLifecycleInstanceMigrated                  = "instance-migrated"
 	EventLifecycleInstancePaused                    = "instance-paused"
+	EventLifecycleInstancePlaced                    = "instance-placed"
 	EventLifecycleInstanceReady                     = "instance-ready"
 	EventLifecycleInstanceRenamed                   = "instance-renamed"
 	EventLifecycleInstanceRestarted                 = "instance-restarted"
@@ -20,6 +20,12 @@ type InstanceResources struct {
 	CPUCores     uint64 `json:"cpu_cores"`
 	MemorySize   uint64 `json:"memory_size"`
 	RootDiskSize uint64 `json:"root_disk_size"`
+
+	// Storage pool of the root disk, empty if the instance has no root disk
+	// Example: default
+	//
+	// API extension: instances_scriptlet_instance_resources_root_disk_pool
+	RootDiskPool string `json:"root_disk_pool"`
 }
 
 // InstancePlacement represents the instance placement request.
@@ -30,4 +36,419 @@ type InstancePlacement struct {
 
 	Reason  string `json:"reason"`
 	Project string `json:"project"`
+
+	// Free-form labels of the instance, derived from its user.* configuration keys
+	// Example: {"rack": "r1"}
+	//
+	// API extension: instances_scriptlet_labels
+	Labels map[string]string `json:"labels"`
+
+	// Boot priority of the instance, from its boot.autostart.priority configuration key (empty when unset)
+	// Example: 10
+	//
+	// API extension: instances_scriptlet_boot_priority
+	BootPriority string `json:"boot_priority"`
+
+	// Priority or QoS class of the instance, from its user.priority configuration key (empty when unset)
+	// Example: batch
+	//
+	// API extension: instances_scriptlet_priority
+	Priority string `json:"priority"`
+
+	// Origin of each configuration key, the name of the profile it comes from or empty if set on the instance
+	// Example: {"limits.cpu": "default", "user.tier": ""}
+	//
+	// API extension: instances_scriptlet_get_instance_config_sources
+	ConfigSources map[string]string `json:"config_sources"`
+}
+
+// MemberGPU represents a GPU card on a cluster member.
+//
+// API extension: instances_scriptlet_get_member_gpus.
+type MemberGPU struct {
+	// PCI address
+	// Example: 0000:01:00.0
+	PCIAddress string `json:"pci_address"`
+
+	// Name of the vendor
+	// Example: NVIDIA Corporation
+	Vendor string `json:"vendor"`
+
+	// PCI ID of the vendor
+	// Example: 10de
+	VendorID string `json:"vendor_id"`
+
+	// Name of the product
+	// Example: GA102GL [A40]
+	Product string `json:"product"`
+
+	// PCI ID of the product
+	// Example: 2235
+	ProductID string `json:"product_id"`
+
+	// Kernel driver currently associated with the card
+	// Example: nvidia
+	Driver string `json:"driver"`
+
+	// NUMA node the card is a part of
+	// Example: 0
+	NUMANode uint64 `json:"numa_node"`
+
+	// Number of SR-IOV virtual functions currently configured
+	// Example: 4
+	VFsCurrent uint64 `json:"vfs_current"`
+
+	// Maximum number of SR-IOV virtual functions
+	// Example: 16
+	VFsMaximum uint64 `json:"vfs_maximum"`
+
+	// Number of mediated devices which can still be created (across all profiles)
+	// Example: 2
+	MdevAvailable uint64 `json:"mdev_available"`
+}
+
+// MemberNetworkPort represents a network port on a cluster member.
+//
+// API extension: instances_scriptlet_get_member_network_ports.
+type MemberNetworkPort struct {
+	// Name of the network interface
+	// Example: eth0
+	ID string `json:"id"`
+
+	// MAC address of the port
+	// Example: 00:23:a4:01:01:6f
+	Address string `json:"address"`
+
+	// PCI address of the card the port belongs to
+	// Example: 0000:0d:00.0
+	PCIAddress string `json:"pci_address"`
+
+	// Kernel driver currently associated with the card
+	// Example: atlantic
+	Driver string `json:"driver"`
+
+	// NUMA node the card is a part of
+	// Example: 0
+	NUMANode uint64 `json:"numa_node"`
+
+	// Whether a link was detected
+	// Example: true
+	LinkDetected bool `json:"link_detected"`
+
+	// Current speed (Mbit/s)
+	// Example: 10000
+	LinkSpeed uint64 `json:"link_speed"`
+
+	// Number of SR-IOV VFs currently configured on the card
+	// Example: 4
+	VFsCurrent uint64 `json:"vfs_current"`
+
+	// Maximum number of SR-IOV VFs supported by the card
+	// Example: 64
+	VFsMaximum uint64 `json:"vfs_maximum"`
+
+	// Number of SR-IOV VFs of the card that can still be allocated
+	// Example: 62
+	VFsFree uint64 `json:"vfs_free"`
+}
+
+// MemberHugepages represents the huge pages of a given size on a cluster member.
+//
+// API extension: instances_scriptlet_get_member_hugepages.
+type MemberHugepages struct {
+	// Size of the huge pages (bytes)
+	// Example: 2097152
+	PageSize uint64 `json:"page_size"`
+
+	// Total number of huge pages
+	// Example: 1024
+	Total uint64 `json:"total"`
+
+	// Number of free huge pages
+	// Example: 512
+	Free uint64 `json:"free"`
+}
+
+// ClusterResources represents the resources aggregated across the candidate cluster members.
+//
+// API extension: instances_scriptlet_get_cluster_resources.
+type ClusterResources struct {
+	// Number of members included in the totals
+	// Example: 3
+	Members int `json:"members"`
+
+	// Total number of CPU threads
+	// Example: 48
+	CPUTotal uint64 `json:"cpu_total"`
+
+	// Total memory (bytes)
+	// Example: 103079215104
+	MemoryTotal uint64 `json:"memory_total"`
+
+	// Used memory (bytes)
+	// Example: 34359738368
+	MemoryUsed uint64 `json:"memory_used"`
+
+	// Total size of the disks (bytes)
+	// Example: 3000592982016
+	StorageTotal uint64 `json:"storage_total"`
+}
+
+// ClusterLimits represents the cluster-wide settings relevant to instance placement.
+//
+// API extension: instances_scriptlet_get_cluster_limits.
+type ClusterLimits struct {
+	// Number of seconds after which an unresponsive member is considered offline
+	// Example: 20
+	OfflineThreshold int64 `json:"offline_threshold"`
+
+	// Number of seconds after which an offline member is evacuated (0 if disabled)
+	// Example: 0
+	HealingThreshold int64 `json:"healing_threshold"`
+
+	// Load difference (percentage) between the most and least busy members that triggers re-balancing (0 if disabled)
+	// Example: 20
+	RebalanceThreshold int64 `json:"rebalance_threshold"`
+
+	// Maximum number of instances moved in a single re-balancing run
+	// Example: 1
+	RebalanceBatch int64 `json:"rebalance_batch"`
+
+	// CPU count assumed for virtual machines without limits.cpu (empty for the built-in default)
+	// Example: 2
+	VMDefaultCPU string `json:"vm_default_cpu"`
+
+	// Memory assumed for virtual machines without limits.memory (empty for the built-in default)
+	// Example: 2GiB
+	VMDefaultMemory string `json:"vm_default_memory"`
+
+	// Root disk size assumed for virtual machines without a root disk size (empty for the built-in default)
+	// Example: 20GiB
+	VMDefaultRootDiskSize string `json:"vm_default_root_disk_size"`
+}
+
+// ClusterLoadBalance represents the spread of a load metric across the candidate cluster members.
+//
+// API extension: instances_scriptlet_cluster_load_balance.
+type ClusterLoadBalance struct {
+	// Load metric the statistics are computed on
+	// Example: instances
+	Metric string `json:"metric"`
+
+	// Load of each candidate member
+	// Example: {"server01": 4, "server02": 2}
+	Loads map[string]float64 `json:"loads"`
+
+	// Mean load across the candidate members
+	// Example: 3
+	Mean float64 `json:"mean"`
+
+	// Standard deviation of the load across the candidate members
+	// Example: 1
+	StdDev float64 `json:"stddev"`
+
+	// Member whose additional load would most reduce the standard deviation
+	// Example: server02
+	Member string `json:"member"`
+}
+
+// StoragePoolDriver represents the driver of a storage pool on a cluster member.
+//
+// API extension: instances_scriptlet_get_storage_pool_driver.
+type StoragePoolDriver struct {
+	// Name of the storage driver
+	// Example: zfs
+	Name string `json:"name"`
+
+	// Whether the storage is shared between cluster members
+	// Example: false
+	Remote bool `json:"remote"`
+
+	// Whether the driver supports optimized images
+	// Example: true
+	OptimizedImages bool `json:"optimized_images"`
+}
+
+// MemberMetricsSample represents a sample of the resource usage of a cluster member.
+//
+// API extension: instances_scriptlet_get_member_metrics.
+type MemberMetricsSample struct {
+	// Time at which the sample was taken (Unix timestamp)
+	// Example: 1700000000
+	Timestamp int64 `json:"timestamp"`
+
+	// Load average over the last minute
+	// Example: 0.5
+	LoadAverage float64 `json:"load_average"`
+
+	// Total system memory (bytes)
+	// Example: 17179869184
+	MemoryTotal uint64 `json:"memory_total"`
+
+	// Used system memory (bytes)
+	// Example: 4294967296
+	MemoryUsed uint64 `json:"memory_used"`
+}
+
+// InstanceSnapshot represents a compact view of an instance snapshot.
+//
+// API extension: instances_scriptlet_get_instance_snapshots.
+type InstanceSnapshot struct {
+	// Name of the snapshot
+	// Example: snap0
+	Name string `json:"name"`
+
+	// Time at which the snapshot was created (Unix timestamp)
+	// Example: 1700000000
+	CreatedAt int64 `json:"created_at"`
+
+	// Time at which the snapshot expires (Unix timestamp, 0 if it doesn't expire)
+	// Example: 1700086400
+	ExpiresAt int64 `json:"expires_at"`
+
+	// Whether the snapshot includes the runtime state
+	// Example: false
+	Stateful bool `json:"stateful"`
+}
+
+// InstanceVolume represents a custom storage volume attached to an instance.
+//
+// API extension: instances_scriptlet_get_instance_volumes.
+type InstanceVolume struct {
+	// Name of the disk device
+	// Example: data
+	Device string `json:"device"`
+
+	// Name of the storage volume
+	// Example: vol1
+	Name string `json:"name"`
+
+	// Name of the storage pool
+	// Example: default
+	Pool string `json:"pool"`
+
+	// Project of the storage volume
+	// Example: default
+	Project string `json:"project"`
+
+	// Cluster member the volume is located on, empty for remote storage pools
+	// Example: server01
+	Location string `json:"location"`
+}
+
+// MemberAutostartFailure represents a recent failure to automatically start an instance on a cluster member.
+//
+// API extension: instances_scriptlet_get_member_autostart_failures.
+type MemberAutostartFailure struct {
+	// Project of the instance
+	// Example: default
+	Project string `json:"project"`
+
+	// Name of the instance, empty if it no longer exists
+	// Example: c1
+	Instance string `json:"instance"`
+
+	// Last error message
+	// Example: Failed to start device "eth0"
+	Message string `json:"message"`
+
+	// Number of failures recorded since the first one
+	// Example: 3
+	Count int `json:"count"`
+
+	// Time of the last failure (Unix timestamp)
+	// Example: 1700000000
+	LastSeenAt int64 `json:"last_seen_at"`
+}
+
+// MemberInstance represents an instance located on a cluster member.
+//
+// API extension: instances_scriptlet_get_member_instances.
+type MemberInstance struct {
+	// Name of the instance
+	// Example: c1
+	Name string `json:"name"`
+
+	// Project of the instance
+	// Example: default
+	Project string `json:"project"`
+
+	// Type of the instance
+	// Example: container
+	Type string `json:"type"`
+}
+
+// InstanceNetworkRequirements represents the network bandwidth requested by the NICs of an instance.
+//
+// API extension: instances_scriptlet_get_instance_network_requirements.
+type InstanceNetworkRequirements struct {
+	// Number of NIC devices
+	// Example: 2
+	NICs int `json:"nics"`
+
+	// Number of NIC devices without an ingress limit
+	// Example: 0
+	UnlimitedIngress int `json:"unlimited_ingress"`
+
+	// Number of NIC devices without an egress limit
+	// Example: 1
+	UnlimitedEgress int `json:"unlimited_egress"`
+
+	// Sum of the ingress limits (bits per second)
+	// Example: 1000000000
+	Ingress uint64 `json:"ingress"`
+
+	// Sum of the egress limits (bits per second)
+	// Example: 100000000
+	Egress uint64 `json:"egress"`
+}
+
+// InstanceConfigSource represents where an instance configuration key comes from.
+//
+// API extension: instances_scriptlet_get_instance_config_sources.
+type InstanceConfigSource struct {
+	// Source of the key, either "profile" or "instance"
+	// Example: profile
+	Source string `json:"source"`
+
+	// Name of the profile the key comes from, empty if set on the instance
+	// Example: default
+	Profile string `json:"profile"`
+}
+
+// CurrentTime represents the current time in UTC.
+//
+// API extension: instances_scriptlet_now.
+type CurrentTime struct {
+	// Unix timestamp
+	// Example: 1700000000
+	Unix int64 `json:"unix"`
+
+	// Year
+	// Example: 2023
+	Year int `json:"year"`
+
+	// Month (1 to 12)
+	// Example: 11
+	Month int `json:"month"`
+
+	// Day of the month (1 to 31)
+	// Example: 14
+	Day int `json:"day"`
+
+	// Hour (0 to 23)
+	// Example: 22
+	Hour int `json:"hour"`
+
+	// Minute (0 to 59)
+	// Example: 13
+	Minute int `json:"minute"`
+
+	// Second (0 to 59)
+	// Example: 20
+	Second int `json:"second"`
+
+	// Day of the week (0 for Sunday to 6 for Saturday)
+	// Example: 2
+	Weekday int `json:"weekday"`
 }